@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// useArchiveDir points the backup paths to a new temporary directory, laid
+// out like a snapshot directory; the returned function removes it
+func useArchiveDir() (string, func()) {
+	dir, err := ioutil.TempDir("", "cf-plugin-backup")
+	util.FreakOut(err)
+
+	setBackupDir(dir, defaultBackupFile, defaultAppBitsDir)
+
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// openArchive extracts a snapshot archive and points the backup paths to
+// its content; the returned function removes the extracted files
+func openArchive(archivePath string) func() {
+	dir, cleanup := useArchiveDir()
+
+	log.Printf("Extracting %s", archivePath)
+	_, err := util.ExtractArchive(archivePath, dir)
+	if err != nil {
+		cleanup()
+		util.FreakOut(err)
+	}
+
+	return cleanup
+}
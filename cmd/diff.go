@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+var diffJSON bool
+
+var diffMarkers = map[string]string{
+	util.ChangeAdded:   "+",
+	util.ChangeRemoved: "-",
+	util.ChangeChanged: "~",
+}
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "backup-diff OLD_BACKUP NEW_BACKUP",
+	Short: "Compare two backup snapshots",
+	Long: `Compare two backup snapshots.
+Resources are matched by name, not guid, and reported as added,
+removed or changed, with the changed fields listed one by one.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stdout, "backup-diff needs exactly two backup files.")
+			fmt.Fprintln(os.Stdout, "Usage:", cmd.Use)
+			os.Exit(1)
+		}
+
+		oldBackup, err := readBackupFile(args[0])
+		util.FreakOut(err)
+		newBackup, err := readBackupFile(args[1])
+		util.FreakOut(err)
+
+		changes, err := util.DiffBackups(oldBackup, newBackup)
+		util.FreakOut(err)
+
+		if diffJSON {
+			changesJSON, err := json.MarshalIndent(changes, "", " ")
+			util.FreakOut(err)
+			fmt.Println(string(changesJSON))
+			return
+		}
+		printChanges(os.Stdout, changes)
+	},
+}
+
+func readBackupFile(path string) (*models.BackupModel, error) {
+	backupJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := util.ReadBackupJSON(backupJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse backup file %s: %s", path, err)
+	}
+
+	return backup, nil
+}
+
+func formatDiffValue(value interface{}) string {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(valueJSON)
+}
+
+func printChanges(out io.Writer, changes []util.ResourceChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No differences found.")
+		return
+	}
+
+	for _, change := range changes {
+		fmt.Fprintln(out, diffMarkers[change.Change], change.Type, change.Key)
+		for _, field := range change.Fields {
+			fmt.Fprintf(out, "    %s: %s -> %s\n", field.Field, formatDiffValue(field.Old), formatDiffValue(field.New))
+		}
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "Print the differences as JSON")
+}
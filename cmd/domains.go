@@ -0,0 +1,23 @@
+package cmd
+
+import "strings"
+
+// domainMap rewrites the domains of the backup for a target with other
+// domains. A mapping applies to the domain and to the domains below it,
+// e.g. apps.example.com=apps.test.com maps foo.apps.example.com to
+// foo.apps.test.com. The longest matching domain wins.
+type domainMap map[string]string
+
+// target returns the domain of the target that replaces domain
+func (domains domainMap) target(domain string) string {
+	matched := ""
+	for old := range domains {
+		if (domain == old || strings.HasSuffix(domain, "."+old)) && len(old) > len(matched) {
+			matched = old
+		}
+	}
+	if matched == "" {
+		return domain
+	}
+	return strings.TrimSuffix(domain, matched) + domains[matched]
+}
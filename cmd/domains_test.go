@@ -0,0 +1,62 @@
+package cmd
+
+import "testing"
+
+func TestDomainMap_Target(t *testing.T) {
+	domains := domainMap{
+		"example.com":      "test.com",
+		"apps.example.com": "apps.staging.test.com",
+	}
+	for domain, expected := range map[string]string{
+		"example.com":          "test.com",
+		"sys.example.com":      "sys.test.com",
+		"apps.example.com":     "apps.staging.test.com",
+		"foo.apps.example.com": "foo.apps.staging.test.com",
+		"notexample.com":       "notexample.com",
+		"example.org":          "example.org",
+	} {
+		if target := domains.target(domain); target != expected {
+			t.Errorf("expected %s to map to %s, got %s", domain, expected, target)
+		}
+	}
+}
+
+func TestRestoreSharedDomain_ExistingDomainIsSkipped(t *testing.T) {
+	restoreCC = newDryRunConnection(fakeCC{
+		"/v2/shared_domains?q=name:apps.test.com": `{"total_results":1,"total_pages":1,"next_url":null,"resources":[
+			{"metadata":{"guid":"d1","url":"/v2/shared_domains/d1"},"entity":{"name":"apps.test.com"}}]}`,
+	})
+	defer func() { restoreCC = nil }()
+
+	guid, action, err := restoreSharedDomain(sharedDomain{Name: "apps.test.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guid != "d1" || action != actionSkipped {
+		t.Fatalf("expected the existing domain d1 to be skipped, got %s %s", guid, action)
+	}
+}
+
+// domainTakenCC answers every write with CF-DomainNameTaken
+type domainTakenCC struct {
+	fakeCC
+}
+
+func (cc domainTakenCC) Invoke(method, path string, body []byte) ([]byte, error) {
+	if method != "GET" {
+		return []byte(`{"error_code":"CF-DomainNameTaken","description":"The domain name is taken"}`), nil
+	}
+	return cc.fakeCC.Invoke(method, path, body)
+}
+
+func TestRestorePrivateDomain_UnknownExistingDomainIsAnError(t *testing.T) {
+	// The name is taken, e.g. by a shared domain, but no private domain
+	// has it
+	restoreCC = domainTakenCC{}
+	defer func() { restoreCC = nil }()
+
+	guid, _, err := restorePrivateDomain(privateDomain{Name: "apps.test.com", OwningOrganizationGUID: "o1"})
+	if err == nil || guid != "" {
+		t.Fatalf("expected an error without the guid of the existing domain, got %q %v", guid, err)
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+var driftJSON bool
+
+// driftCmd represents the drift command
+var driftCmd = &cobra.Command{
+	Use:   "backup-drift",
+	Short: "Compare the current snapshot with the live CloudFoundry",
+	Long: `Compare the current snapshot with the live CloudFoundry.
+The CC is crawled the same way backup-snapshot does, without writing
+anything, and the resources added, deleted or modified since the
+snapshot are listed, together with the apps whose bits changed.
+The command exits with code 3 when drift is found, with code 2 when
+the live state could not be read completely, and with code 1 when the
+check could not run at all, e.g. without a snapshot or a login.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		snapshot, err := readBackupFile(backupFile)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stdout, "Failed to read backup information file %s.\nYou can create one with `backup-snapshot`.\n", backupFile)
+			os.Exit(exitFailure)
+		}
+		util.FreakOut(err)
+
+		util.SetCrawlWorkers(crawlWorkers)
+		report := &failureReport{}
+		ccAPI, err := util.NewHTTPCCApi(CliConnection)
+		util.FreakOut(err)
+		live := collectBackupModel(ccAPI, report)
+		util.FreakOut(ccAPI.AuthError())
+		if len(report.failures) > 0 {
+			// Resources missing from the live state would show up as drift
+			report.print(os.Stderr)
+			os.Exit(exitPartial)
+		}
+
+		changes, err := util.DiffBackups(snapshot, &live)
+		util.FreakOut(err)
+
+		if driftJSON {
+			changesJSON, err := json.MarshalIndent(changes, "", " ")
+			util.FreakOut(err)
+			fmt.Println(string(changesJSON))
+		} else {
+			printDrift(os.Stdout, changes)
+		}
+
+		os.Exit(driftExitCode(changes))
+	},
+}
+
+// driftExitCode tells cron whether changes were found, with a code of its
+// own so that drift is not mistaken for a failed check
+func driftExitCode(changes []util.ResourceChange) int {
+	if len(changes) > 0 {
+		return exitDrift
+	}
+	return exitSuccess
+}
+
+// changedBits returns the keys of the apps whose package hash changed
+func changedBits(changes []util.ResourceChange) []string {
+	var apps []string
+	for _, change := range changes {
+		if change.Type != "app" {
+			continue
+		}
+		for _, field := range change.Fields {
+			if field.Field == "package_hash" {
+				apps = append(apps, change.Key)
+			}
+		}
+	}
+	return apps
+}
+
+func printDrift(out io.Writer, changes []util.ResourceChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No drift found.")
+		return
+	}
+
+	printChanges(out, changes)
+
+	if apps := changedBits(changes); len(apps) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "App bits changed since the snapshot:")
+		for _, app := range apps {
+			fmt.Fprintln(out, "-", app)
+		}
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(driftCmd)
+
+	driftCmd.Flags().BoolVar(&driftJSON, "json", false, "Print the drift as JSON")
+	driftCmd.Flags().IntVar(&crawlWorkers, "parallel", 4, "Number of CC requests run concurrently while crawling")
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func TestDriftExitCode_DriftHasItsOwnCode(t *testing.T) {
+	if code := driftExitCode(nil); code != exitSuccess {
+		t.Fatal("expected success without drift, got", code)
+	}
+	code := driftExitCode([]util.ResourceChange{{Type: "app", Key: "o1/s1/a1"}})
+	if code != exitDrift {
+		t.Fatal("expected the drift exit code, got", code)
+	}
+	for _, failure := range []int{exitFailure, exitPartial} {
+		if code == failure {
+			t.Fatal("drift shares its exit code with a failure:", code)
+		}
+	}
+}
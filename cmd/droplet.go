@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// dropletPollInterval is the time between two checks of an uploaded droplet
+var dropletPollInterval = time.Second
+
+// dropletProcessTimeout bounds the processing of an uploaded droplet
+const dropletProcessTimeout = 10 * time.Minute
+
+// restoreDroplet uploads the droplet saved at path and makes it the current
+// droplet of the app with appGUID, so that the app runs without staging
+func restoreDroplet(packager util.Packager, appGUID, path string, droplet models.Droplet) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"relationships": map[string]interface{}{
+			"app": map[string]interface{}{"data": map[string]string{"guid": appGUID}},
+		},
+		"process_types": droplet.ProcessTypes,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := invokeCC("POST", "/v3/droplets", body)
+	if err != nil {
+		return err
+	}
+	var created struct {
+		GUID string `json:"guid"`
+	}
+	if json.Unmarshal(resp, &created) != nil || created.GUID == "" {
+		return fmt.Errorf("Could not create a droplet: %s", string(resp))
+	}
+
+	if err := packager.UploadStagedDroplet(created.GUID, path); err != nil {
+		return err
+	}
+	if _, planning := restoreCC.(*dryRunConnection); !planning {
+		if err := waitForDroplet(created.GUID); err != nil {
+			return err
+		}
+	}
+
+	body, err = json.Marshal(map[string]interface{}{"data": map[string]string{"guid": created.GUID}})
+	if err != nil {
+		return err
+	}
+	_, err = invokeCC("PATCH", "/v3/apps/"+appGUID+"/relationships/current_droplet", body)
+	return err
+}
+
+// waitForDroplet polls an uploaded droplet until the CC processed it
+func waitForDroplet(guid string) error {
+	deadline := time.Now().Add(dropletProcessTimeout)
+	for {
+		resp, err := invokeCC("GET", "/v3/droplets/"+guid, nil)
+		if err != nil {
+			return err
+		}
+		var droplet struct {
+			State string `json:"state"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(resp, &droplet); err != nil {
+			return err
+		}
+
+		switch droplet.State {
+		case "STAGED":
+			return nil
+		case "FAILED", "EXPIRED":
+			return fmt.Errorf("Droplet %s %s: %s", guid, strings.ToLower(droplet.State), droplet.Error)
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("Timed out after %s processing droplet %s", dropletProcessTimeout, guid)
+		}
+		time.Sleep(dropletPollInterval)
+	}
+}
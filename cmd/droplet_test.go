@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func TestRestoreDroplet_PlansCreateUploadAndAssign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "droplets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a1.tgz")
+	if err := ioutil.WriteFile(path, []byte("droplet"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := newDryRunConnection(fakeCC{
+		"/v2/apps/a2": `{"metadata":{"guid":"a2"},"entity":{"name":"web"}}`,
+	})
+	restoreCC = conn
+	defer func() { restoreCC = nil }()
+
+	err = restoreDroplet(&dryRunPackager{conn: conn}, "a2", path, models.Droplet{
+		GUID:         "d1",
+		ProcessTypes: map[string]string{"web": "bundle exec rackup"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{ action, kind, name string }{
+		{"create", "droplet", "for app web"},
+		{"upload", "droplet", path + " to droplet for app web"},
+		{"bind", "droplet", "for app web as current droplet of app web"},
+	}
+	if len(conn.plan.steps) != len(expected) {
+		t.Fatalf("unexpected plan %+v", conn.plan.steps)
+	}
+	for i, step := range conn.plan.steps {
+		if step.Action != expected[i].action || step.Kind != expected[i].kind || step.Name != expected[i].name {
+			t.Fatalf("unexpected step %d %+v", i, step)
+		}
+	}
+}
+
+func TestStartApps_UploadsBitsBeforeTheDroplet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "droplets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	zipPath := filepath.Join(dir, "a1.zip")
+	if err := ioutil.WriteFile(zipPath, []byte("bits"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a1.tgz"), []byte("droplet"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dropletsDir string) { backupDropletsDir = dropletsDir }(backupDropletsDir)
+	backupDropletsDir = dir
+
+	conn := newDryRunConnection(fakeCC{
+		"/v2/apps/a2": `{"metadata":{"guid":"a2"},"entity":{"name":"web"}}`,
+	})
+	restoreCC = conn
+	defer func() { restoreCC = nil }()
+
+	pending := &pendingApp{
+		entry:   restoreEntry{Type: "app", Key: "o1/s1/web", OldGUID: "a1", NewGUID: "a2"},
+		app:     app{Name: "web", State: "STOPPED"},
+		zipPath: zipPath,
+	}
+	startApps([]*pendingApp{pending}, map[string]models.Droplet{"a1": {GUID: "d1"}}, &failureReport{})
+
+	if !pending.dropletRestored {
+		t.Fatal("expected the droplet to be restored")
+	}
+	var kinds []string
+	for _, step := range conn.plan.steps {
+		kinds = append(kinds, step.Action+" "+step.Kind)
+	}
+	if len(kinds) < 3 || kinds[0] != "upload app bits" || kinds[1] != "create droplet" {
+		t.Fatalf("expected the bits to be uploaded before the droplet is created, got %v", kinds)
+	}
+}
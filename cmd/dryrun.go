@@ -0,0 +1,535 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+const dryRunGUIDPrefix = "dry-run-"
+
+// planStep is a single write that a restore would send to the CC
+type planStep struct {
+	Action string
+	Kind   string
+	Name   string
+	Method string
+	Path   string
+	Note   string
+}
+
+// restorePlan holds the ordered list of writes a restore would perform
+type restorePlan struct {
+	steps []planStep
+}
+
+func (plan *restorePlan) add(step planStep) {
+	plan.steps = append(plan.steps, step)
+}
+
+func (plan *restorePlan) print(w io.Writer) {
+	if len(plan.steps) == 0 {
+		fmt.Fprintln(w, "Restore plan: nothing to do.")
+		return
+	}
+
+	fmt.Fprintf(w, "Restore plan (%d steps, no changes were made):\n", len(plan.steps))
+	for i, step := range plan.steps {
+		line := fmt.Sprintf("%4d. %-7s %-22s %s", i+1, step.Action, step.Kind, step.Name)
+		if step.Note != "" {
+			line = fmt.Sprintf("%s (%s)", line, step.Note)
+		}
+		fmt.Fprintf(w, "%s\n        %s %s\n", line, step.Method, step.Path)
+	}
+}
+
+// dryRunKinds maps CC collections to the resource names used in the plan
+var dryRunKinds = map[string]string{
+	"organizations":           "organization",
+	"spaces":                  "space",
+	"apps":                    "app",
+	"routes":                  "route",
+	"shared_domains":          "shared domain",
+	"private_domains":         "private domain",
+	"quota_definitions":       "quota definition",
+	"space_quota_definitions": "space quota definition",
+	"security_groups":         "security group",
+	"users":                   "user",
+	"feature_flags":           "feature flag",
+	"buildpacks":              "buildpack",
+
+	"service_instances":               "service instance",
+	"user_provided_service_instances": "user provided service",
+	"service_bindings":                "service binding",
+	"service_keys":                    "service key",
+}
+
+// dryRunUniqueKeys lists the entity fields that make a new resource collide
+// with an existing one, and the error code the CC answers with
+var dryRunUniqueKeys = map[string]struct {
+	fields    []string
+	errorCode string
+}{
+	"organizations":           {[]string{"name"}, "CF-OrganizationNameTaken"},
+	"spaces":                  {[]string{"name", "organization_guid"}, "CF-SpaceNameTaken"},
+	"apps":                    {[]string{"name", "space_guid"}, "CF-AppNameTaken"},
+	"routes":                  {[]string{"host", "domain_guid", "path"}, "CF-RouteHostTaken"},
+	"shared_domains":          {[]string{"name"}, "CF-DomainNameTaken"},
+	"private_domains":         {[]string{"name"}, "CF-DomainNameTaken"},
+	"quota_definitions":       {[]string{"name"}, "CF-QuotaDefinitionNameTaken"},
+	"space_quota_definitions": {[]string{"name", "organization_guid"}, "CF-SpaceQuotaDefinitionNameTaken"},
+	"security_groups":         {[]string{"name"}, "CF-SecurityGroupNameTaken"},
+	"buildpacks":              {[]string{"name", "stack"}, "CF-BuildpackNameTaken"},
+
+	"service_instances":               {[]string{"name", "space_guid"}, "CF-ServiceInstanceNameTaken"},
+	"user_provided_service_instances": {[]string{"name", "space_guid"}, "CF-ServiceInstanceNameTaken"},
+	"service_bindings":                {[]string{"app_guid", "service_instance_guid"}, "CF-ServiceBindingAppServiceTaken"},
+	"service_keys":                    {[]string{"name", "service_instance_guid"}, "CF-ServiceKeyNameTaken"},
+}
+
+// dryRunConnection wraps the CC api for `backup-restore --dry-run`.
+// Reads go to the live CC so the plan reflects the current foundation; writes
+// are recorded in the plan and answered with the response the CC would send.
+// Resources planned for creation or deletion are overlaid on later reads, so
+// lookups done by the restore see the foundation as it would be at that point.
+type dryRunConnection struct {
+	util.CCApi
+
+	plan *restorePlan
+
+	nextGUID int
+	planned  map[string][]map[string]interface{}
+	deleted  map[string]bool
+	labels   map[string]string
+}
+
+func newDryRunConnection(ccAPI util.CCApi) *dryRunConnection {
+	return &dryRunConnection{
+		CCApi:   ccAPI,
+		plan:    &restorePlan{},
+		planned: make(map[string][]map[string]interface{}),
+		deleted: make(map[string]bool),
+		labels:  make(map[string]string),
+	}
+}
+
+type ccRequest struct {
+	method string
+	path   string
+	body   map[string]interface{}
+}
+
+// InvokeGet reads from the live CC
+func (conn *dryRunConnection) InvokeGet(path string) (string, error) {
+	output, err := conn.get(path)
+	return string(output), err
+}
+
+// Invoke intercepts writes to the CC
+func (conn *dryRunConnection) Invoke(method, path string, body []byte) ([]byte, error) {
+	if method == "GET" {
+		return conn.get(path)
+	}
+
+	request := ccRequest{method: method, path: path}
+	if len(body) > 0 {
+		json.Unmarshal(body, &request.body)
+	}
+
+	response := conn.write(request)
+	if response == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(response)
+}
+
+func splitCCPath(path string) ([]string, map[string]string) {
+	filters := make(map[string]string)
+
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	for _, param := range strings.Split(query, "&") {
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		for _, filter := range strings.Split(strings.TrimPrefix(param, "q="), ";") {
+			if kv := strings.SplitN(filter, ":", 2); len(kv) == 2 {
+				filters[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	return strings.Split(strings.Trim(strings.TrimPrefix(path, "/v2/"), "/"), "/"), filters
+}
+
+func entityValue(entity map[string]interface{}, key string) string {
+	if entity[key] == nil {
+		return ""
+	}
+	return fmt.Sprint(entity[key])
+}
+
+func matchesFilters(entity map[string]interface{}, filters map[string]string) bool {
+	for key, value := range filters {
+		if entityValue(entity, key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// get reads from the live CC and overlays the planned changes on collections
+func (conn *dryRunConnection) get(path string) ([]byte, error) {
+	output, err := conn.CCApi.Invoke("GET", path, nil)
+	if err != nil {
+		return output, err
+	}
+
+	segments, filters := splitCCPath(path)
+	if len(segments) != 1 || (len(conn.planned[segments[0]]) == 0 && len(conn.deleted) == 0) {
+		return output, nil
+	}
+
+	var collection map[string]interface{}
+	if json.Unmarshal(output, &collection) != nil {
+		return output, nil
+	}
+	if _, isCollection := collection["total_results"]; !isCollection {
+		return output, nil
+	}
+	resources, _ := collection["resources"].([]interface{})
+
+	var result []interface{}
+	for _, resource := range resources {
+		metadata, _ := resource.(map[string]interface{})["metadata"].(map[string]interface{})
+		if guid, ok := metadata["guid"].(string); ok && conn.deleted[guid] {
+			continue
+		}
+		result = append(result, resource)
+	}
+	if collection["next_url"] == nil {
+		for _, resource := range conn.planned[segments[0]] {
+			if matchesFilters(resource["entity"].(map[string]interface{}), filters) {
+				result = append(result, resource)
+			}
+		}
+	}
+	if result == nil {
+		result = []interface{}{}
+	}
+
+	collection["resources"] = result
+	collection["total_results"] = len(result)
+
+	return json.Marshal(collection)
+}
+
+func (conn *dryRunConnection) exists(collection string, entity map[string]interface{}) bool {
+	unique, found := dryRunUniqueKeys[collection]
+	if !found {
+		return false
+	}
+
+	filters := make(map[string]string)
+	var query []string
+	for _, field := range unique.fields {
+		value := entityValue(entity, field)
+		filters[field] = value
+		if value != "" {
+			query = append(query, field+":"+value)
+		}
+	}
+
+	for _, resource := range conn.planned[collection] {
+		if matchesFilters(resource["entity"].(map[string]interface{}), filters) {
+			return true
+		}
+	}
+	for _, value := range filters {
+		if strings.HasPrefix(value, dryRunGUIDPrefix) {
+			// The parent is only planned, so nothing can exist below it yet
+			return false
+		}
+	}
+
+	path := "/v2/" + collection
+	if len(query) > 0 {
+		path += "?q=" + strings.Join(query, ";")
+	}
+	output, err := conn.CCApi.Invoke("GET", path, nil)
+	if err != nil {
+		return false
+	}
+
+	var resources struct {
+		Resources []struct {
+			Metadata map[string]interface{} `json:"metadata"`
+			Entity   map[string]interface{} `json:"entity"`
+		} `json:"resources"`
+	}
+	if json.Unmarshal(output, &resources) != nil {
+		return false
+	}
+	for _, resource := range resources.Resources {
+		if guid, _ := resource.Metadata["guid"].(string); conn.deleted[guid] {
+			continue
+		}
+		if matchesFilters(resource.Entity, filters) {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns a human readable name for a resource referenced by guid
+func (conn *dryRunConnection) label(collection, guid string) string {
+	if label, found := conn.labels[guid]; found {
+		return label
+	}
+
+	label := guid
+	output, err := conn.CCApi.Invoke("GET", "/v2/"+collection+"/"+guid, nil)
+	if err == nil {
+		var resource struct {
+			Entity map[string]interface{} `json:"entity"`
+		}
+		if json.Unmarshal(output, &resource) == nil && resource.Entity != nil {
+			for _, field := range []string{"name", "username", "host"} {
+				if name := entityValue(resource.Entity, field); name != "" {
+					label = name
+					break
+				}
+			}
+		}
+	}
+
+	conn.labels[guid] = label
+	return label
+}
+
+func (conn *dryRunConnection) domainLabel(guid string) string {
+	if label, found := conn.labels[guid]; found {
+		return label
+	}
+	label := conn.label("shared_domains", guid)
+	if label == guid {
+		delete(conn.labels, guid)
+		label = conn.label("private_domains", guid)
+	}
+	return label
+}
+
+func (conn *dryRunConnection) entityLabel(collection string, entity map[string]interface{}) string {
+	switch collection {
+	case "service_bindings":
+		return fmt.Sprintf("%s to app %s",
+			conn.label("service_instances", entityValue(entity, "service_instance_guid")),
+			conn.label("apps", entityValue(entity, "app_guid")))
+	case "routes":
+		label := entityValue(entity, "host")
+		if domain := entityValue(entity, "domain_guid"); domain != "" {
+			label = label + "." + conn.domainLabel(domain)
+		}
+		return label + entityValue(entity, "path")
+	}
+	return entityValue(entity, "name")
+}
+
+func (conn *dryRunConnection) write(request ccRequest) map[string]interface{} {
+	segments, _ := splitCCPath(request.path)
+	step := planStep{Method: request.method, Path: request.path, Kind: segments[0]}
+	if kind, found := dryRunKinds[segments[0]]; found {
+		step.Kind = kind
+	}
+
+	var response map[string]interface{}
+
+	switch {
+	case request.method == "POST" && len(segments) == 1:
+		step.Action = "create"
+		if segments[0] == "service_bindings" {
+			step.Action = "bind"
+		}
+		step.Name = conn.entityLabel(segments[0], request.body)
+		if conn.exists(segments[0], request.body) {
+			step.Action = "skip"
+			step.Note = "already exists"
+			response = map[string]interface{}{
+				"code":        0,
+				"error_code":  dryRunUniqueKeys[segments[0]].errorCode,
+				"description": fmt.Sprintf("%s %s already exists", step.Kind, step.Name),
+			}
+			break
+		}
+
+		conn.nextGUID++
+		guid := fmt.Sprintf("%s%d", dryRunGUIDPrefix, conn.nextGUID)
+		response = map[string]interface{}{
+			"metadata": map[string]interface{}{"guid": guid, "url": "/v2/" + segments[0] + "/" + guid},
+			"entity":   request.body,
+		}
+		conn.planned[segments[0]] = append(conn.planned[segments[0]], response)
+		conn.labels[guid] = step.Name
+
+	case request.method == "DELETE" && len(segments) == 2:
+		step.Action = "delete"
+		step.Name = conn.label(segments[0], segments[1])
+		conn.deleted[segments[1]] = true
+
+	case request.method == "PUT" && segments[0] == "users" && len(segments) == 4:
+		targetCollection := "spaces"
+		if strings.HasSuffix(segments[2], "organizations") {
+			targetCollection = "organizations"
+		}
+		step.Action = "bind"
+		step.Kind = "user role"
+		step.Name = fmt.Sprintf("%s as %s of %s", conn.label("users", segments[1]), segments[2], conn.label(targetCollection, segments[3]))
+		response = map[string]interface{}{
+			"metadata": map[string]interface{}{"guid": segments[1]},
+			"entity":   map[string]interface{}{},
+		}
+
+	case request.method == "PUT" && segments[0] == "apps" && len(segments) == 4 && segments[2] == "routes":
+		step.Action = "bind"
+		step.Kind = "route"
+		step.Name = fmt.Sprintf("%s to app %s", conn.label("routes", segments[3]), conn.label("apps", segments[1]))
+		response = map[string]interface{}{
+			"metadata": map[string]interface{}{"guid": segments[1]},
+			"entity":   map[string]interface{}{},
+		}
+
+	case request.method == "PUT" && segments[0] == "config" && len(segments) == 3:
+		step.Action = "update"
+		step.Kind = dryRunKinds[segments[1]]
+		step.Name = segments[2]
+		response = map[string]interface{}{"name": segments[2]}
+		for k, v := range request.body {
+			response[k] = v
+		}
+		if segments[1] != "feature_flags" {
+			step.Action = "bind"
+			step.Kind = "security group"
+			step.Name = fmt.Sprintf("%s as %s default", conn.label("security_groups", segments[2]), strings.TrimSuffix(segments[1], "_security_groups"))
+		}
+
+	case request.method == "PUT" && len(segments) == 2:
+		step.Action = "update"
+		step.Name = conn.entityLabel(segments[0], request.body)
+		if step.Name == "" {
+			step.Name = conn.label(segments[0], segments[1])
+		}
+		response = map[string]interface{}{
+			"metadata": map[string]interface{}{"guid": segments[1], "url": request.path},
+			"entity":   request.body,
+		}
+
+	case request.method == "POST" && request.path == "/v3/droplets":
+		appGUID := ""
+		if relationships, ok := request.body["relationships"].(map[string]interface{}); ok {
+			app, _ := relationships["app"].(map[string]interface{})
+			data, _ := app["data"].(map[string]interface{})
+			appGUID = entityValue(data, "guid")
+		}
+		step.Action = "create"
+		step.Kind = "droplet"
+		step.Name = "for app " + conn.label("apps", appGUID)
+		conn.nextGUID++
+		guid := fmt.Sprintf("%s%d", dryRunGUIDPrefix, conn.nextGUID)
+		conn.labels[guid] = step.Name
+		response = map[string]interface{}{"guid": guid, "state": "AWAITING_UPLOAD"}
+
+	case request.method == "PATCH" && len(segments) == 5 && segments[3] == "relationships" && segments[4] == "current_droplet":
+		data, _ := request.body["data"].(map[string]interface{})
+		step.Action = "bind"
+		step.Kind = "droplet"
+		step.Name = fmt.Sprintf("%s as current droplet of app %s", conn.label("droplets", entityValue(data, "guid")), conn.label("apps", segments[2]))
+		response = request.body
+
+	default:
+		step.Action = strings.ToLower(request.method)
+		step.Name = request.path
+	}
+
+	conn.plan.add(step)
+	return response
+}
+
+// dryRunPackager records app bits uploads instead of sending them
+type dryRunPackager struct {
+	conn *dryRunConnection
+}
+
+// GetDroplet is not available in dry-run mode
+func (packager *dryRunPackager) GetDroplet(guid string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("cannot download droplets in dry-run mode")
+}
+
+// SaveDropletToFile is not available in dry-run mode
+func (packager *dryRunPackager) SaveDropletToFile(filePath string, data io.Reader) error {
+	return fmt.Errorf("cannot save droplets in dry-run mode")
+}
+
+// GetBuildpack is not available in dry-run mode
+func (packager *dryRunPackager) GetBuildpack(guid string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("cannot download buildpacks in dry-run mode")
+}
+
+// UploadBuildpack checks the buildpack bits exist and records the upload in the plan
+func (packager *dryRunPackager) UploadBuildpack(guid, path, filename string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	packager.conn.plan.add(planStep{
+		Action: "upload",
+		Kind:   "buildpack bits",
+		Name:   fmt.Sprintf("%s as %s to buildpack %s", path, filename, packager.conn.label("buildpacks", guid)),
+		Method: "PUT",
+		Path:   "/v2/buildpacks/" + guid + "/bits",
+	})
+	return nil
+}
+
+// UploadDroplet checks the app bits exist and records the upload in the plan
+func (packager *dryRunPackager) UploadDroplet(guid, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	packager.conn.plan.add(planStep{
+		Action: "upload",
+		Kind:   "app bits",
+		Name:   fmt.Sprintf("%s to app %s", path, packager.conn.label("apps", guid)),
+		Method: "PUT",
+		Path:   "/v2/apps/" + guid + "/bits",
+	})
+	return nil
+}
+
+// GetStagedDroplet is not available in dry-run mode
+func (packager *dryRunPackager) GetStagedDroplet(appGUID string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("cannot download droplets in dry-run mode")
+}
+
+// UploadStagedDroplet checks the droplet exists and records the upload in the plan
+func (packager *dryRunPackager) UploadStagedDroplet(dropletGUID, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	packager.conn.plan.add(planStep{
+		Action: "upload",
+		Kind:   "droplet",
+		Name:   fmt.Sprintf("%s to droplet %s", path, packager.conn.label("droplets", dropletGUID)),
+		Method: "POST",
+		Path:   "/v3/droplets/" + dropletGUID + "/upload",
+	})
+	return nil
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// fakeCC answers reads from responses and fails the test on any write
+type fakeCC map[string]string
+
+func (responses fakeCC) InvokeGet(path string) (string, error) {
+	response, err := responses.Invoke("GET", path, nil)
+	return string(response), err
+}
+
+func (responses fakeCC) Invoke(method, path string, body []byte) ([]byte, error) {
+	if method != "GET" {
+		panic("dry-run sent a write to the CC: " + method + " " + path)
+	}
+	if response, found := responses[path]; found {
+		return []byte(response), nil
+	}
+	return []byte(`{"total_results":0,"total_pages":0,"next_url":null,"resources":[]}`), nil
+}
+
+func TestDryRun_CreateIsPlannedAndVisibleToLaterReads(t *testing.T) {
+	conn := newDryRunConnection(fakeCC(nil))
+
+	resp, err := conn.Invoke("POST", "/v2/organizations", []byte(`{"name":"o1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guid, _, err := getResult(resp, "name", "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(guid, dryRunGUIDPrefix) {
+		t.Fatal("expected a planned guid, got", guid)
+	}
+
+	resp, err = conn.Invoke("GET", "/v2/organizations?q=name:o1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var collection struct {
+		TotalResults int `json:"total_results"`
+	}
+	json.Unmarshal(resp, &collection)
+	if collection.TotalResults != 1 {
+		t.Fatal("planned org not visible, total_results =", collection.TotalResults)
+	}
+
+	if len(conn.plan.steps) != 1 || conn.plan.steps[0].Action != "create" || conn.plan.steps[0].Name != "o1" {
+		t.Fatal("unexpected plan", conn.plan.steps)
+	}
+}
+
+func TestDryRun_ExistingOrgIsReportedAsTaken(t *testing.T) {
+	conn := newDryRunConnection(fakeCC{
+		"/v2/organizations?q=name:o1": `{"total_results":1,"total_pages":1,"next_url":null,"resources":[
+			{"metadata":{"guid":"91656f3b"},"entity":{"name":"o1"}}]}`,
+	})
+
+	resp, _ := conn.Invoke("POST", "/v2/organizations", []byte(`{"name":"o1"}`))
+
+	_, obj, err := getResult(resp, "name", "o1")
+	if err == nil || obj["error_code"] != "CF-OrganizationNameTaken" {
+		t.Fatal("expected CF-OrganizationNameTaken, got", obj)
+	}
+	if conn.plan.steps[0].Action != "skip" {
+		t.Fatal("expected skip step, got", conn.plan.steps[0].Action)
+	}
+}
+
+func TestDryRun_DeleteHidesResourceFromLaterReads(t *testing.T) {
+	conn := newDryRunConnection(fakeCC{
+		"/v2/quota_definitions?q=name:q1": `{"total_results":1,"total_pages":1,"next_url":null,"resources":[
+			{"metadata":{"guid":"8d331df5"},"entity":{"name":"q1"}}]}`,
+		"/v2/quota_definitions/8d331df5": `{"metadata":{"guid":"8d331df5"},"entity":{"name":"q1"}}`,
+	})
+
+	conn.Invoke("DELETE", "/v2/quota_definitions/8d331df5", nil)
+	resp, _ := conn.Invoke("POST", "/v2/quota_definitions", []byte(`{"name":"q1"}`))
+
+	if _, _, err := getResult(resp, "name", "q1"); err != nil {
+		t.Fatal("re-creating a deleted quota should be planned:", err)
+	}
+	if conn.plan.steps[0].Action != "delete" || conn.plan.steps[0].Name != "q1" {
+		t.Fatal("unexpected delete step", conn.plan.steps[0])
+	}
+	if conn.plan.steps[1].Action != "create" {
+		t.Fatal("unexpected create step", conn.plan.steps[1])
+	}
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// Exit codes of the snapshot, restore and drift commands
+const (
+	exitSuccess = 0
+	// exitFailure means the command could not run, or nothing succeeded
+	exitFailure = 1
+	// exitPartial means the command finished but some resources failed
+	exitPartial = 2
+	// exitDrift means backup-drift ran and found drift; no failure uses it
+	exitDrift = 3
+)
+
+// resourceFailure is a resource that could not be captured or restored
+type resourceFailure struct {
+	Kind string
+	Name string
+	Err  error
+}
+
+// failureReport collects the outcome of every resource a command handles
+type failureReport struct {
+	succeeded int
+	failures  []resourceFailure
+	// entries hold the details of every restored resource
+	entries []restoreEntry
+	// journal records the restored resources for --resume, if set
+	journal *restoreJournal
+}
+
+// record counts a handled resource, remembering it when err is set. Crawl
+// errors are split into one failure per resource that could not be read;
+// the resources around them were still captured.
+func (report *failureReport) record(kind, name string, err error) {
+	if err == nil {
+		report.succeeded++
+		return
+	}
+
+	if crawlErr, partial := err.(*util.CrawlError); partial {
+		report.succeeded++
+		for _, failure := range crawlErr.Failures {
+			report.failures = append(report.failures, resourceFailure{Kind: kind, Name: failure.URL, Err: failure.Err})
+		}
+		return
+	}
+
+	report.failures = append(report.failures, resourceFailure{Kind: kind, Name: name, Err: err})
+}
+
+// restored records the outcome of restoring a resource, warning about it
+// right away when it failed; it returns whether the resource was restored
+func (report *failureReport) restored(entry restoreEntry, err error) bool {
+	if err != nil {
+		showWarning(fmt.Sprintf("Error restoring %s %s: %s", entry.Type, entry.Key, err.Error()))
+		entry.Action = actionFailed
+		entry.Error = err.Error()
+		if ccErr, fromCC := err.(*ccError); fromCC {
+			entry.ErrorCode = ccErr.Code
+		}
+	}
+	report.entries = append(report.entries, entry)
+	report.record(entry.Type, entry.Key, err)
+	if err == nil && report.journal != nil {
+		if err := report.journal.record(entry); err != nil {
+			showWarning(fmt.Sprintf("Could not write the restore journal %s: %s", report.journal.path, err.Error()))
+		}
+	}
+	return err == nil
+}
+
+// resumed tells whether the previous run of a resumed restore completed
+// the step of entry. If so, entry gets the guid restored back then and is
+// counted as restored.
+func (report *failureReport) resumed(entry *restoreEntry) bool {
+	if report.journal == nil {
+		return false
+	}
+	done, found := report.journal.completed(*entry)
+	if !found {
+		return false
+	}
+
+	showInfo(fmt.Sprintf("Skipping %s %s, restored by the previous run", entry.Type, entry.Key))
+	entry.NewGUID = done.NewGUID
+	entry.Action = actionResumed
+	report.entries = append(report.entries, *entry)
+	report.record(entry.Type, entry.Key, nil)
+	return true
+}
+
+// resuming tells whether the restore continues an interrupted one
+func (report *failureReport) resuming() bool {
+	return report.journal != nil && report.journal.resuming()
+}
+
+// skipped records a resource that was left out on purpose
+func (report *failureReport) skipped(entry restoreEntry, reason string) {
+	showWarning(fmt.Sprintf("Skipping %s %s: %s", entry.Type, entry.Key, reason))
+	entry.Action = actionSkipped
+	entry.Error = reason
+	report.entries = append(report.entries, entry)
+}
+
+func (report *failureReport) exitCode() int {
+	switch {
+	case len(report.failures) == 0:
+		return exitSuccess
+	case report.succeeded == 0:
+		return exitFailure
+	}
+	return exitPartial
+}
+
+func (report *failureReport) print(out io.Writer) {
+	if len(report.failures) == 0 {
+		return
+	}
+
+	fmt.Fprintf(out, "\n%d resources failed, %d succeeded:\n", len(report.failures), report.succeeded)
+	for _, failure := range report.failures {
+		if failure.Name == "" {
+			fmt.Fprintf(out, "  %s: %s\n", failure.Kind, failure.Err.Error())
+		} else {
+			fmt.Fprintf(out, "  %s %s: %s\n", failure.Kind, failure.Name, failure.Err.Error())
+		}
+	}
+}
+
+// exitWithReport prints the failure summary and exits with the code
+// matching the outcome; err aborts the command as a whole
+func exitWithReport(report *failureReport, err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err.Error())
+		report.print(os.Stderr)
+		os.Exit(exitFailure)
+	}
+
+	report.print(os.Stderr)
+	if code := report.exitCode(); code != exitSuccess {
+		os.Exit(code)
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func TestFailureReport_ExitCodes(t *testing.T) {
+	report := &failureReport{}
+	report.record("organization", "o1", nil)
+	if code := report.exitCode(); code != exitSuccess {
+		t.Fatalf("expected %d without failures, got %d", exitSuccess, code)
+	}
+
+	report.record("space", "o1/s1", errors.New("Got CF-SpaceNameTaken"))
+	if code := report.exitCode(); code != exitPartial {
+		t.Fatalf("expected %d with some failures, got %d", exitPartial, code)
+	}
+
+	report = &failureReport{}
+	report.record("organization", "o1", errors.New("connection refused"))
+	if code := report.exitCode(); code != exitFailure {
+		t.Fatalf("expected %d when nothing succeeded, got %d", exitFailure, code)
+	}
+}
+
+func TestFailureReport_CrawlErrorsArePartial(t *testing.T) {
+	report := &failureReport{}
+	report.record("orgs", "", &util.CrawlError{Failures: []*util.ResourceError{
+		{URL: "/v2/spaces/s1/apps", Err: errors.New("timeout")},
+	}})
+
+	if code := report.exitCode(); code != exitPartial {
+		t.Fatalf("expected %d, got %d", exitPartial, code)
+	}
+
+	var out bytes.Buffer
+	report.print(&out)
+	if !strings.Contains(out.String(), "orgs /v2/spaces/s1/apps: timeout") {
+		t.Fatalf("failed resource missing from summary:\n%s", out.String())
+	}
+}
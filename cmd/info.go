@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -21,52 +20,83 @@ var infoCmd = &cobra.Command{
 It includes a summary of organizations, spaces and apps
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
-		backupJSON, err := ioutil.ReadFile(backupFile)
+		cleanup := func() {}
+		if archive, _ := cmd.Flags().GetString("archive"); archive != "" {
+			cleanup = openArchive(archive)
+		}
+
+		// os.Exit skips deferred calls, so the extracted archive is
+		// removed before handling the error
+		err := showBackupInfo()
+		cleanup()
 		if os.IsNotExist(err) {
 			fmt.Fprintf(os.Stdout, "Failed to read backup information file %s.\nYou can create one with `backup-snapshot`.\n", backupFile)
 			os.Exit(1)
 		}
 		util.FreakOut(err)
+	},
+}
 
-		backupModel := models.BackupModel{}
-		err = json.Unmarshal(backupJSON, &backupModel)
-		util.FreakOut(err)
+// showBackupInfo prints the summary of the backup file
+func showBackupInfo() error {
+	backupJSON, err := ioutil.ReadFile(backupFile)
+	if err != nil {
+		return err
+	}
+
+	backupModel, err := util.ReadBackupJSON(backupJSON)
+	if err != nil {
+		return err
+	}
+
+	printBackupMetadata(os.Stdout, backupModel.Metadata)
+	fmt.Println()
+
+	orgs, err := util.RestoreOrganizations(backupModel.Organizations)
+	skippedErr, partial := err.(*models.SkippedError)
+	if err != nil && !partial {
+		return err
+	}
 
-		if backupModel.Organizations == nil {
-			fmt.Println("No organizations backed up.")
-		} else {
-			resources := util.RestoreOrgResourceModels(backupModel.Organizations)
-			if resources != nil {
-				for _, org := range *resources {
-					fmt.Println("-", "Org ", org.Entity["name"])
-					if org.Entity["spaces"] != nil {
-						for _, space := range *(org.Entity["spaces"].(*[]*models.ResourceModel)) {
-							fmt.Println("--", "Space ", space.Entity["name"])
-							if space.Entity["apps"] != nil {
-								for _, app := range *(space.Entity["apps"].(*[]*models.ResourceModel)) {
-									fmt.Println("---", "App ", app.Entity["name"])
-
-									appGUID := app.Metadata["guid"].(string)
-									appZipPath := filepath.Join(backupDir, backupAppBitsDir, appGUID+".zip")
-									appZip, err := os.Open(appZipPath)
-									if err == nil {
-										zipStat, err := appZip.Stat()
-										if err == nil {
-											fmt.Println("----", "Package Size", zipStat.Size(), "Bytes")
-										}
-										appZip.Close()
-									}
-								}
-							}
-						}
-					}
+	if orgs == nil {
+		fmt.Println("No organizations backed up.")
+	}
+	for _, org := range orgs {
+		fmt.Println("-", "Org ", org.Name)
+		for _, space := range org.Spaces {
+			fmt.Println("--", "Space ", space.Name)
+			for _, instance := range space.ServiceInstances {
+				fmt.Println("---", "Service ", instance.Entity["name"])
+			}
+			for _, app := range space.Apps {
+				fmt.Println("---", "App ", app.Name)
+
+				appZipPath := filepath.Join(backupAppBitsDir, app.GUID+".zip")
+				if zipStat, err := os.Stat(appZipPath); err == nil {
+					fmt.Println("----", "Package Size", zipStat.Size(), "Bytes")
 				}
 			}
 		}
-	},
+	}
+
+	if backupModel.Buildpacks != nil {
+		for _, buildpack := range *util.RestoreBuildpackResourceModels(backupModel.Buildpacks) {
+			fmt.Println("-", "Buildpack ", buildpack.Entity["name"], "position", buildpack.Entity["position"])
+		}
+	}
+
+	if partial {
+		fmt.Println()
+		fmt.Println("Left out of the backup:")
+		for _, skipped := range skippedErr.Skipped {
+			fmt.Println("-", skipped.Kind, skipped.Key()+":", skipped.Error())
+		}
+	}
+	return nil
 }
 
 func init() {
+	infoCmd.Flags().String("archive", "", "Show information about a snapshot archive instead of the current directory")
 	RootCmd.AddCommand(infoCmd)
 
 	// Here you will define your flags and configuration settings.
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// restoreJournalSuffix names the journal kept next to the backup file, or
+// next to the archive when restoring from one
+const restoreJournalSuffix = ".journal"
+
+// restoreJournal records every step a restore completed, one JSON entry
+// per line, with the guids it restored. `backup-restore --resume` skips the
+// steps of the previous run and reuses their guids.
+type restoreJournal struct {
+	path string
+	// previous holds the steps completed by the interrupted run, by
+	// type and key
+	previous map[string]restoreEntry
+	// file is nil when the journal is only read, e.g. on a dry run
+	file *os.File
+}
+
+func journalKey(entry restoreEntry) string {
+	return entry.Type + " " + entry.Key
+}
+
+// openRestoreJournal starts a new journal at path, or continues the one of
+// the previous run when resume is set. A read only journal is not written.
+func openRestoreJournal(path string, resume, readOnly bool) (*restoreJournal, error) {
+	journal := &restoreJournal{path: path, previous: make(map[string]restoreEntry)}
+
+	var kept bytes.Buffer
+	if resume {
+		if err := journal.load(); err != nil {
+			return nil, err
+		}
+		// Rewrite the journal without the entry an interruption may have
+		// cut short, so that new entries start on a line of their own
+		keys := make([]string, 0, len(journal.previous))
+		for key := range journal.previous {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		encoder := json.NewEncoder(&kept)
+		for _, key := range keys {
+			if err := encoder.Encode(journal.previous[key]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if readOnly {
+		return journal, nil
+	}
+
+	if err := new(util.CFFileWriter).WriteFile(path, &kept, 0644); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	journal.file = file
+	return journal, nil
+}
+
+func (journal *restoreJournal) load() error {
+	file, err := os.Open(journal.path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("No restore journal %s to resume from", journal.path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry restoreEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		journal.previous[journalKey(entry)] = entry
+	}
+	return scanner.Err()
+}
+
+// completed returns the entry of the previous run for the step of entry
+func (journal *restoreJournal) completed(entry restoreEntry) (restoreEntry, bool) {
+	done, found := journal.previous[journalKey(entry)]
+	return done, found
+}
+
+// resuming tells whether the journal continues a previous run
+func (journal *restoreJournal) resuming() bool {
+	return len(journal.previous) != 0
+}
+
+// record appends a completed step, syncing it to disk so that it survives
+// the restore being killed right after
+func (journal *restoreJournal) record(entry restoreEntry) error {
+	if journal.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := journal.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return journal.file.Sync()
+}
+
+// close closes the journal, removing it when the restore completed, as
+// there is nothing left to resume
+func (journal *restoreJournal) close(completed bool) error {
+	if journal.file == nil {
+		return nil
+	}
+
+	if err := journal.file.Close(); err != nil {
+		return err
+	}
+	if completed {
+		return os.Remove(journal.path)
+	}
+	return nil
+}
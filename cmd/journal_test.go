@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func TestRestoreJournal_ResumeSkipsCompletedSteps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cf-backup.json"+restoreJournalSuffix)
+
+	journal, err := openRestoreJournal(path, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &failureReport{journal: journal}
+	report.restored(restoreEntry{Type: "organization", Key: "o1", OldGUID: "old-o1", NewGUID: "new-o1", Action: actionCreated}, nil)
+	report.restored(restoreEntry{Type: "space", Key: "o1/s1", OldGUID: "old-s1", Action: actionCreated}, &ccError{Code: "CF-SpaceNameTaken"})
+	if err := journal.close(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// An interrupted write leaves half an entry behind
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"type":"space","key":"o1/s2","new_gu`)
+	file.Close()
+
+	journal, err = openRestoreJournal(path, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = &failureReport{journal: journal}
+
+	org := restoreEntry{Type: "organization", Key: "o1", OldGUID: "old-o1"}
+	if !report.resumed(&org) || org.NewGUID != "new-o1" || org.Action != actionResumed {
+		t.Fatalf("the org should be resumed with its guid, got %+v", org)
+	}
+	for _, key := range []string{"o1/s1", "o1/s2"} {
+		space := restoreEntry{Type: "space", Key: key}
+		if report.resumed(&space) {
+			t.Fatalf("space %s was not completed", key)
+		}
+	}
+
+	report.restored(restoreEntry{Type: "space", Key: "o1/s1", NewGUID: "new-s1"}, nil)
+	if err := journal.close(false); err != nil {
+		t.Fatal(err)
+	}
+	journal, err = openRestoreJournal(path, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if space, done := journal.completed(restoreEntry{Type: "space", Key: "o1/s1"}); !done || space.NewGUID != "new-s1" {
+		t.Fatalf("the space restored after resuming should be recorded, got %+v", space)
+	}
+}
+
+func TestRestoreJournal_ResumeNeedsAJournal(t *testing.T) {
+	if _, err := openRestoreJournal(filepath.Join(os.TempDir(), "missing.journal"), true, false); err == nil {
+		t.Fatal("expected an error without a journal to resume from")
+	}
+}
+
+func TestRestoreJournal_SpaceQuotasAreKeyedByOrg(t *testing.T) {
+	restoreCC = newDryRunConnection(fakeCC(nil))
+	defer func() { restoreCC = nil }()
+	journal := &restoreJournal{previous: map[string]restoreEntry{
+		"space_quota o1/q1": {Type: "space_quota", Key: "o1/q1", NewGUID: "new-o1-q1"},
+	}}
+	report := &failureReport{journal: journal}
+
+	spaceQuotas := []models.Quota{
+		{GUID: "old-o1-q1", Name: "q1", OrganizationGUID: "old-o1"},
+		{GUID: "old-o2-q1", Name: "q1", OrganizationGUID: "old-o2"},
+	}
+	guids := map[string]string{}
+	restoreSpaceQuotasWithGuids(spaceQuotas, &guids, "o1", "old-o1", "new-o1", nil, report)
+	restoreSpaceQuotasWithGuids(spaceQuotas, &guids, "o2", "old-o2", "new-o2", nil, report)
+
+	if guids["old-o1-q1"] != "new-o1-q1" {
+		t.Fatal("expected the quota of o1 to be resumed, got", guids["old-o1-q1"])
+	}
+	if guid := guids["old-o2-q1"]; guid == "" || guid == "new-o1-q1" {
+		t.Fatal("expected the quota of o2 to be restored on its own, got", guid)
+	}
+}
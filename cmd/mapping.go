@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseMappings reads old=new pairs given with the flag named flag, and
+// from file when set. The file holds one pair per line; blank lines and
+// lines starting with # are ignored. Pairs given on the command line win
+// over the ones of the file.
+func parseMappings(flag string, pairs []string, file string) (map[string]string, error) {
+	mappings := make(map[string]string)
+
+	if file != "" {
+		content, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+
+		scanner := bufio.NewScanner(content)
+		for line := 1; scanner.Scan(); line++ {
+			pair := strings.TrimSpace(scanner.Text())
+			if pair == "" || strings.HasPrefix(pair, "#") {
+				continue
+			}
+			if err := addMapping(mappings, pair); err != nil {
+				return nil, fmt.Errorf("invalid mapping at %s:%d: %s", file, line, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pair := range pairs {
+		if err := addMapping(mappings, pair); err != nil {
+			return nil, fmt.Errorf("invalid --%s %q: %s", flag, pair, err)
+		}
+	}
+
+	return mappings, nil
+}
+
+func addMapping(mappings map[string]string, pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("expected old=new")
+	}
+	mappings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
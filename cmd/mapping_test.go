@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseMappings_FileAndFlags(t *testing.T) {
+	file, err := ioutil.TempFile("", "stack-map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# Stacks of the old foundation\ncflinuxfs2 = cflinuxfs3\n\nopensuse42=sle15\n")
+	file.Close()
+
+	mappings, err := parseMappings("stack-map", []string{"opensuse42=cflinuxfs3"}, file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mappings) != 2 || mappings["cflinuxfs2"] != "cflinuxfs3" || mappings["opensuse42"] != "cflinuxfs3" {
+		t.Fatalf("unexpected mappings %v", mappings)
+	}
+}
+
+func TestParseMappings_InvalidPairs(t *testing.T) {
+	for _, pair := range []string{"cflinuxfs2", "=cflinuxfs3", "cflinuxfs2="} {
+		_, err := parseMappings("stack-map", []string{pair}, "")
+		if err == nil || !strings.Contains(err.Error(), "--stack-map") {
+			t.Fatalf("expected an error for %q, got %v", pair, err)
+		}
+	}
+}
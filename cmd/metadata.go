@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// PluginVersion is the version of the plugin, recorded in every snapshot
+var PluginVersion string
+
+// apiVersionWarnGap is how many minor CC API versions the restore target
+// may lag behind the snapshot before the restore warns about it
+const apiVersionWarnGap = 10
+
+// snapshotBitsTypes are captured besides the resources of the backup json
+var snapshotBitsTypes = []string{"app_bits", "buildpack_bits", "droplet_bits"}
+
+// capturedResourceTypes lists the collections held by a backup
+func capturedResourceTypes(backup models.BackupModel) ([]string, error) {
+	backup.Metadata = nil
+
+	backupJSON, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections map[string]interface{}
+	if err := json.Unmarshal(backupJSON, &collections); err != nil {
+		return nil, err
+	}
+
+	var types []string
+	for name, collection := range collections {
+		switch collection.(type) {
+		case []interface{}, map[string]interface{}:
+			types = append(types, name)
+		}
+	}
+	sort.Strings(types)
+
+	return append(types, snapshotBitsTypes...), nil
+}
+
+func newBackupMetadata(ccAPI util.CCApi, backup models.BackupModel, startedAt time.Time) (*models.BackupMetadataModel, error) {
+	endpoint, err := CliConnection.ApiEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	user, err := CliConnection.Username()
+	if err != nil {
+		return nil, err
+	}
+	apiVersion, err := util.GetAPIVersion(ccAPI)
+	if err != nil {
+		log.Printf("Could not read the CC API version: %v", err)
+	}
+	resourceTypes, err := capturedResourceTypes(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.BackupMetadataModel{
+		APIEndpoint:   endpoint,
+		APIVersion:    apiVersion,
+		PluginVersion: PluginVersion,
+		StartedAt:     startedAt,
+		User:          user,
+		ResourceTypes: resourceTypes,
+	}, nil
+}
+
+func printBackupMetadata(out io.Writer, metadata *models.BackupMetadataModel) {
+	if metadata == nil {
+		fmt.Fprintln(out, "No snapshot metadata (taken with an older plugin version).")
+		return
+	}
+
+	fmt.Fprintln(out, "API endpoint:  ", metadata.APIEndpoint)
+	fmt.Fprintln(out, "API version:   ", metadata.APIVersion)
+	fmt.Fprintln(out, "Plugin version:", metadata.PluginVersion)
+	fmt.Fprintln(out, "Taken by:      ", metadata.User)
+	fmt.Fprintln(out, "Started at:    ", metadata.StartedAt.Format(time.RFC3339))
+	if metadata.FinishedAt.IsZero() {
+		fmt.Fprintln(out, "Finished at:    not finished")
+	} else {
+		fmt.Fprintln(out, "Finished at:   ", metadata.FinishedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintln(out, "Resources:     ", strings.Join(metadata.ResourceTypes, ", "))
+}
+
+// apiVersionMuchOlder is true when target lags behind snapshot by a major
+// version or by more than apiVersionWarnGap minor versions
+func apiVersionMuchOlder(target, snapshot string) bool {
+	targetVersion, err := semver.ParseTolerant(target)
+	if err != nil {
+		return false
+	}
+	snapshotVersion, err := semver.ParseTolerant(snapshot)
+	if err != nil {
+		return false
+	}
+
+	if targetVersion.Major != snapshotVersion.Major {
+		return targetVersion.Major < snapshotVersion.Major
+	}
+	return targetVersion.Minor+apiVersionWarnGap < snapshotVersion.Minor
+}
+
+// checkBackupOrigin warns when the snapshot comes from another foundation or
+// from a much newer CC API than the restore target
+func checkBackupOrigin(metadata *models.BackupMetadataModel) {
+	if metadata == nil {
+		showWarning("The snapshot has no metadata, its origin can not be checked")
+		return
+	}
+
+	endpoint, err := CliConnection.ApiEndpoint()
+	if err == nil && metadata.APIEndpoint != "" && strings.TrimSuffix(endpoint, "/") != strings.TrimSuffix(metadata.APIEndpoint, "/") {
+		showWarning(fmt.Sprintf("The snapshot was taken on %s, restoring into a different foundation %s", metadata.APIEndpoint, endpoint))
+	}
+
+	apiVersion, err := util.GetAPIVersion(restoreCC)
+	if err == nil && apiVersionMuchOlder(apiVersion, metadata.APIVersion) {
+		showWarning(fmt.Sprintf("The snapshot was taken with CC API version %s, the target runs the much older %s", metadata.APIVersion, apiVersion))
+	}
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func TestAPIVersionMuchOlder(t *testing.T) {
+	cases := []struct {
+		target, snapshot string
+		muchOlder        bool
+	}{
+		{"2.75.0", "2.75.0", false},
+		{"2.75.0", "2.80.0", false},
+		{"2.75.0", "2.86.0", true},
+		{"2.100.0", "2.75.0", false},
+		{"2.120.0", "3.1.0", true},
+		{"3.1.0", "2.120.0", false},
+		{"2.75.0", "", false},
+	}
+
+	for _, c := range cases {
+		if muchOlder := apiVersionMuchOlder(c.target, c.snapshot); muchOlder != c.muchOlder {
+			t.Errorf("apiVersionMuchOlder(%q, %q) = %v, expected %v", c.target, c.snapshot, muchOlder, c.muchOlder)
+		}
+	}
+}
+
+func TestCapturedResourceTypes(t *testing.T) {
+	types, err := capturedResourceTypes(models.BackupModel{
+		Metadata:      &models.BackupMetadataModel{},
+		Organizations: []interface{}{},
+		FeatureFlags:  []interface{}{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"feature_flags", "organizations", "app_bits", "buildpack_bits", "droplet_bits"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected %v, got %v", expected, types)
+	}
+}
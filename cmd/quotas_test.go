@@ -0,0 +1,28 @@
+package cmd
+
+import "testing"
+
+func TestRestoreSpaceQuota_OnlyReplacesTheQuotaOfItsOrg(t *testing.T) {
+	// o1 and o2 both have a space quota named q1; only o2's exists yet
+	restoreCC = newDryRunConnection(fakeCC{
+		"/v2/space_quota_definitions?q=name:q1;organization_guid:o2": `{"total_results":1,"total_pages":1,"next_url":null,"resources":[
+			{"metadata":{"guid":"o2-q1","url":"/v2/space_quota_definitions/o2-q1"},"entity":{"name":"q1","organization_guid":"o2"}}]}`,
+	})
+	defer func() { restoreCC = nil }()
+
+	_, action, err := restoreSpaceQuota(spacequota{Name: "q1", OrganizationGUID: "o1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != actionCreated {
+		t.Fatal("expected the quota of o1 to be created, not to replace the one of o2, got", action)
+	}
+
+	_, action, err = restoreSpaceQuota(spacequota{Name: "q1", OrganizationGUID: "o2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != actionRecreated {
+		t.Fatal("expected the existing quota of o2 to be recreated, got", action)
+	}
+}
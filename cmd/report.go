@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Actions recorded in the restore report
+const (
+	actionCreated   = "created"
+	actionUpdated   = "updated"
+	actionSkipped   = "skipped"
+	actionRecreated = "deleted+recreated"
+	actionFailed    = "failed"
+	actionStarted   = "started"
+	// actionResumed is a resource restored by the run a --resume continues
+	actionResumed = "resumed"
+)
+
+// ccError is an error response of the CC
+type ccError struct {
+	Code        string
+	Description string
+}
+
+func (err *ccError) Error() string {
+	return fmt.Sprintf("Got %s-%s", err.Code, err.Description)
+}
+
+// restoreEntry is the outcome of restoring a single resource. Key is the
+// natural key of the resource, e.g. org/space/app for apps.
+type restoreEntry struct {
+	Type      string `json:"type"`
+	Key       string `json:"key"`
+	OldGUID   string `json:"old_guid,omitempty"`
+	NewGUID   string `json:"new_guid,omitempty"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// restoreReport is the content of the --report file
+type restoreReport struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	Error      string         `json:"error,omitempty"`
+	Resources  []restoreEntry `json:"resources"`
+}
+
+// writeRestoreReport saves the outcome of every restored resource as JSON;
+// err is the error that aborted the restore, if any
+func writeRestoreReport(path string, report *failureReport, startedAt time.Time, err error) error {
+	content := restoreReport{
+		StartedAt:  startedAt,
+		FinishedAt: time.Now().UTC(),
+		Succeeded:  report.succeeded,
+		Failed:     len(report.failures),
+		Resources:  report.entries,
+	}
+	if err != nil {
+		content.Error = err.Error()
+	}
+	if content.Resources == nil {
+		content.Resources = []restoreEntry{}
+	}
+
+	reportJSON, err := json.MarshalIndent(content, "", " ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, reportJSON, 0644)
+}
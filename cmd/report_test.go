@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func TestRestoreReport_RecordsCCErrorCodes(t *testing.T) {
+	report := &failureReport{}
+
+	_, _, err := getResult([]byte(`{"error_code":"CF-SpaceNameTaken","description":"The space name is taken: s1"}`), "name", "s1")
+	report.restored(restoreEntry{Type: "space", Key: "o1/s1", OldGUID: "old", Action: actionCreated}, err)
+	report.restored(restoreEntry{Type: "organization", Key: "o1", OldGUID: "old-org", NewGUID: "new-org", Action: actionUpdated}, nil)
+
+	dir, err := ioutil.TempDir("", "restore-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "restore-report.json")
+
+	if err := writeRestoreReport(path, report, time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written restoreReport
+	if err := json.Unmarshal(content, &written); err != nil {
+		t.Fatal(err)
+	}
+
+	if written.Succeeded != 1 || written.Failed != 1 || len(written.Resources) != 2 {
+		t.Fatalf("unexpected report %+v", written)
+	}
+	space := written.Resources[0]
+	if space.Action != actionFailed || space.ErrorCode != "CF-SpaceNameTaken" || space.OldGUID != "old" {
+		t.Fatalf("unexpected space entry %+v", space)
+	}
+	org := written.Resources[1]
+	if org.Action != actionUpdated || org.NewGUID != "new-org" || org.Error != "" {
+		t.Fatalf("unexpected org entry %+v", org)
+	}
+}
+
+func TestRestoreSpaceServices_BadEntriesAreReported(t *testing.T) {
+	report := &failureReport{}
+	sp := models.Space{GUID: "s1", Name: "s1", ServiceInstances: []*models.ResourceModel{{
+		Metadata: map[string]interface{}{"guid": "si1"},
+		Entity:   map[string]interface{}{"name": "db", "service_plan_guid": nil},
+	}}}
+	userProvided := []*models.ResourceModel{{
+		Metadata: map[string]interface{}{"guid": "ups1"},
+		Entity:   map[string]interface{}{"name": nil, "space_guid": "s1"},
+	}}
+
+	restoreSpaceServices("o1", sp, "new-s1", &userProvided, nil, map[string]string{}, nil, report)
+
+	if len(report.entries) != 2 {
+		t.Fatalf("expected both instances to be reported, got %+v", report.entries)
+	}
+	for _, entry := range report.entries {
+		if entry.Action != actionFailed || !strings.HasPrefix(entry.Error, "invalid backup:") {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+	}
+	if report.entries[1].Key != "o1/s1/user_provided_service_instances[0]" {
+		t.Fatal("expected the bad instance to be named by its path, got", report.entries[1].Key)
+	}
+}
+
+func TestReportSkipped_OnlySelectedAppsFail(t *testing.T) {
+	skipped := []*models.SkippedResource{
+		{Kind: "app", Organization: "o", Space: "s", App: "web", Field: "organizations[0].spaces[0].apps[0].stack"},
+		{Kind: "route", Organization: "o", Space: "s", App: "api", Field: "organizations[0].spaces[0].apps[1].routes[0].domain"},
+	}
+	selector, err := newRestoreSelector(nil, nil, []string{"o/s/web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := &failureReport{}
+
+	reportSkipped(skipped, selector, report)
+
+	if len(report.entries) != 1 || report.entries[0].Type != "app" || report.entries[0].Key != "o/s/web" ||
+		report.entries[0].Action != actionFailed || report.entries[0].Error != "organizations[0].spaces[0].apps[0].stack was not captured" {
+		t.Fatalf("unexpected entries %+v", report.entries)
+	}
+	if report.exitCode() != exitFailure {
+		t.Fatal("expected the left out app to fail the restore, got", report.exitCode())
+	}
+}
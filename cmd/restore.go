@@ -7,7 +7,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/SUSE/cf-plugin-backup/models"
 	"github.com/SUSE/cf-plugin-backup/util"
@@ -37,25 +39,29 @@ type space struct {
 }
 
 type quota struct {
-	Name                    string  `json:"name"`
-	GUID                    string  `json:"guid"`
-	NonBasicServicesAllowed bool    `json:"non_basic_services_allowed"`
-	TotalServices           float64 `json:"total_services"`
-	TotalRoutes             float64 `json:"total_routes"`
-	MemoryLimit             float64 `json:"memory_limit"`
+	Name                    string `json:"name"`
+	GUID                    string `json:"guid"`
+	NonBasicServicesAllowed bool   `json:"non_basic_services_allowed"`
+	TotalServices           int    `json:"total_services"`
+	TotalRoutes             int    `json:"total_routes"`
+	TotalReservedRoutePorts *int   `json:"total_reserved_route_ports,omitempty"`
+	MemoryLimit             int    `json:"memory_limit"`
+	TotalServiceKeys        *int   `json:"total_service_keys,omitempty"`
+	InstanceMemoryLimit     *int   `json:"instance_memory_limit,omitempty"`
+	AppInstanceLimit        *int   `json:"app_instance_limit,omitempty"`
 }
 
 type spacequota struct {
-	Name                    string  `json:"name"`
-	NonBasicServicesAllowed bool    `json:"non_basic_services_allowed"`
-	TotalServices           float64 `json:"total_services"`
-	TotalRoutes             float64 `json:"total_routes"`
-	TotalReservedRoutePorts float64 `json:"total_reserved_route_ports,omitempty"`
-	MemoryLimit             float64 `json:"memory_limit"`
-	TotalServiceKeys        float64 `json:"total_service_keys,omitempty"`
-	InstanceMemoryLimit     float64 `json:"instance_memory_limit,omitempty"`
-	AppInstanceLimit        float64 `json:"app_instance_limit,omitempty"`
-	OrganizationGUID        string  `json:"organization_guid"`
+	Name                    string `json:"name"`
+	NonBasicServicesAllowed bool   `json:"non_basic_services_allowed"`
+	TotalServices           int    `json:"total_services"`
+	TotalRoutes             int    `json:"total_routes"`
+	TotalReservedRoutePorts *int   `json:"total_reserved_route_ports,omitempty"`
+	MemoryLimit             int    `json:"memory_limit"`
+	TotalServiceKeys        *int   `json:"total_service_keys,omitempty"`
+	InstanceMemoryLimit     *int   `json:"instance_memory_limit,omitempty"`
+	AppInstanceLimit        *int   `json:"app_instance_limit,omitempty"`
+	OrganizationGUID        string `json:"organization_guid"`
 }
 
 type flag struct {
@@ -66,30 +72,30 @@ type flag struct {
 }
 
 type app struct {
-	Name               string        `json:"name"`
-	SpaceGUID          string        `json:"space_guid"`
-	Diego              interface{}   `json:"diego"`
-	Ports              []interface{} `json:"ports"`
-	Memory             interface{}   `json:"memory"`
-	Instances          interface{}   `json:"instances"`
-	DiskQuota          interface{}   `json:"disk_quota"`
-	StackGUID          string        `json:"stack_guid,omitempty"`
-	Command            interface{}   `json:"command"`
-	Buildpack          interface{}   `json:"buildpack,omitempty"`
-	HealthCheckType    interface{}   `json:"health_check_type"`
-	HealthCheckTimeout interface{}   `json:"health_check_timeout"`
-	EnableSSH          interface{}   `json:"enable_ssh"`
-	DockerImage        interface{}   `json:"docker_image,omitempty"`
-	EnvironmentJSON    interface{}   `json:"environment_json"`
-	State              interface{}   `json:"state"`
+	Name               string                 `json:"name"`
+	SpaceGUID          string                 `json:"space_guid"`
+	Diego              bool                   `json:"diego"`
+	Ports              []int                  `json:"ports,omitempty"`
+	Memory             int                    `json:"memory"`
+	Instances          int                    `json:"instances"`
+	DiskQuota          int                    `json:"disk_quota"`
+	StackGUID          string                 `json:"stack_guid,omitempty"`
+	Command            *string                `json:"command"`
+	Buildpack          *string                `json:"buildpack,omitempty"`
+	HealthCheckType    *string                `json:"health_check_type"`
+	HealthCheckTimeout *int                   `json:"health_check_timeout"`
+	EnableSSH          bool                   `json:"enable_ssh"`
+	DockerImage        string                 `json:"docker_image,omitempty"`
+	EnvironmentJSON    map[string]interface{} `json:"environment_json"`
+	State              string                 `json:"state,omitempty"`
 }
 
 type route struct {
-	DomainGUID string      `json:"domain_guid"`
-	SpaceGUID  string      `json:"space_guid"`
-	Port       interface{} `json:"port"`
-	Host       interface{} `json:"host"`
-	Path       interface{} `json:"path"`
+	DomainGUID string `json:"domain_guid"`
+	SpaceGUID  string `json:"space_guid"`
+	Port       *int   `json:"port"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
 }
 
 type securityGroup struct {
@@ -109,127 +115,202 @@ type privateDomain struct {
 	OwningOrganizationGUID string `json:"owning_organization_guid"`
 }
 
+type serviceInstance struct {
+	Name            string                 `json:"name"`
+	SpaceGUID       string                 `json:"space_guid"`
+	ServicePlanGUID string                 `json:"service_plan_guid"`
+	Parameters      map[string]interface{} `json:"parameters,omitempty"`
+	Tags            []interface{}          `json:"tags,omitempty"`
+}
+
+type userProvidedServiceInstance struct {
+	Name            string                 `json:"name"`
+	SpaceGUID       string                 `json:"space_guid"`
+	Credentials     map[string]interface{} `json:"credentials,omitempty"`
+	SyslogDrainURL  *string                `json:"syslog_drain_url,omitempty"`
+	RouteServiceURL *string                `json:"route_service_url,omitempty"`
+	Tags            []interface{}          `json:"tags,omitempty"`
+}
+
+type serviceBinding struct {
+	AppGUID             string `json:"app_guid"`
+	ServiceInstanceGUID string `json:"service_instance_guid"`
+}
+
+type serviceKey struct {
+	Name                string `json:"name"`
+	ServiceInstanceGUID string `json:"service_instance_guid"`
+}
+
+type buildpack struct {
+	Name     string      `json:"name,omitempty"`
+	Position interface{} `json:"position,omitempty"`
+	Enabled  interface{} `json:"enabled,omitempty"`
+	Locked   interface{} `json:"locked,omitempty"`
+	Stack    interface{} `json:"stack,omitempty"`
+}
+
+// servicePlanRef identifies a service plan across foundations
+type servicePlanRef struct {
+	Service string
+	Plan    string
+}
+
+const (
+	serviceInstancePollInterval = 5 * time.Second
+	serviceInstanceTimeout      = 30 * time.Minute
+)
+
+// restoreCC sends the requests of the restore to the CC
+var restoreCC util.CCApi
+
+// restoreOptions holds the flags of the restore command
+type restoreOptions struct {
+	includeSecurityGroups   bool
+	includeQuotaDefinitions bool
+	dryRun                  bool
+	selector                *restoreSelector
+	// restage uploads the bits of the apps instead of their droplets
+	restage bool
+	// stacks re-points apps and buildpacks to replacement stacks
+	stacks stackMap
+	// domains rewrites the domains and the routes under them
+	domains domainMap
+	// wait polls the started apps until they run, for up to waitTimeout
+	wait        bool
+	waitTimeout time.Duration
+}
+
 func showInfo(sMessage string) {
-	log.Printf(sMessage)
+	if _, planning := restoreCC.(*dryRunConnection); planning {
+		return
+	}
+	log.Print(sMessage)
 }
 
 func showWarning(sMessage string) {
 	log.Printf("WARNING: %s\n", sMessage)
 }
 
-func restorePrivateDomain(domain privateDomain) (string, error) {
+func restorePrivateDomain(domain privateDomain) (string, string, error) {
 	showInfo(fmt.Sprintf("Restoring private domain: %s", domain.Name))
 	oJSON, err := json.Marshal(domain)
-	util.FreakOut(err)
+	if err != nil {
+		return "", "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/private_domains", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/private_domains", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create private domain %s, exception message: %s",
-			domain.Name, err.Error()))
+		return "", "", err
+	}
+	result, obj, err := getResult(resp, "name", domain.Name)
+	if err != nil && obj != nil && obj["error_code"] == "CF-DomainNameTaken" {
+		// The domain already exists; keep it and route through it
+		showInfo(fmt.Sprintf("Private domain %s already exists", domain.Name))
+		guid := getGUIDByQuery("private_domains", "name:"+domain.Name)
+		if guid == "" {
+			return "", "", fmt.Errorf("Could not find the existing private domain %s", domain.Name)
+		}
+		return guid, actionSkipped, nil
 	}
-	result, _, err := getResult(resp, "name", domain.Name)
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring private domain %s: %s", domain.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored private domain %s", domain.Name))
+		return "", "", err
 	}
-	return result, nil
+	showInfo(fmt.Sprintf("Successfully restored private domain %s", domain.Name))
+	return result, actionCreated, nil
 }
 
-func restoreUserRole(user, space, role string) {
+func restoreUserRole(user, space, role string) error {
 	showInfo(fmt.Sprintf("Restoring role for User: %s", user))
 
-	userID := getUserID(user)
+	userID, err := getUserID(user)
+	if err != nil {
+		return err
+	}
 	if userID == "" {
-		showWarning(fmt.Sprintf("Could not find user: %s", user))
-	} else {
-		path := fmt.Sprintf("/v2/users/%s/%s/%s", userID, role, space)
-		resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-			path, "-X", "PUT")
-		if err != nil {
-			showWarning(fmt.Sprintf("Could not create user association %s, exception message: %s",
-				user, err.Error()))
-		}
-		_, _, err = getResult(resp, "", "")
-		if err != nil {
-			showWarning(fmt.Sprintf("Error restoring user role %s for user %s: %s", role, user, err.Error()))
-		} else {
-			showInfo(fmt.Sprintf("Successfully restored user role %s for user %s", role, user))
-		}
+		return fmt.Errorf("Could not find user: %s", user)
+	}
+
+	path := fmt.Sprintf("/v2/users/%s/%s/%s", userID, role, space)
+	resp, err := invokeCC("PUT", path, nil)
+	if err != nil {
+		return err
 	}
+	_, _, err = getResult(resp, "", "")
+	if err != nil {
+		return err
+	}
+	showInfo(fmt.Sprintf("Successfully restored user role %s for user %s", role, user))
+	return nil
 }
 
-func getUserID(user string) string {
-	resources := util.GetResources(CliConnection, "/v2/users", 1)
+func getUserID(user string) (string, error) {
+	resources, err := util.GetResources(restoreCC, "/v2/users", 1)
+	if err != nil {
+		return "", err
+	}
 	for _, u := range resources {
 		if u.Entity["username"] != nil {
 			if u.Entity["username"].(string) == user {
-				return u.Metadata["guid"].(string)
+				return u.Metadata["guid"].(string), nil
 			}
 		}
 	}
 
-	return ""
+	return "", nil
 }
 
-func restoreOrg(org org) string {
+func restoreOrg(org org) (string, string, error) {
 	showInfo(fmt.Sprintf("Restoring organization: %s", org.Name))
 	oJSON, err := json.Marshal(org)
-	util.FreakOut(err)
+	if err != nil {
+		return "", "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/organizations", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/organizations", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create organization %s, exception message: %s",
-			org.Name, err.Error()))
+		return "", "", err
 	}
 	result, obj, err := getResult(resp, "name", org.Name)
+	action := actionCreated
 	if err != nil && obj != nil && obj["error_code"] == "CF-OrganizationNameTaken" {
 		// The org already exists... maybe we don't need to restore
 		guid := getGUIDByQuery("organizations", "name:"+org.Name)
 		if guid != "" {
-			resp, err = CliConnection.CliCommandWithoutTerminalOutput("curl",
-				"/v2/organizations/"+guid, "-H", "Content-Type: application/json",
-				"-d", string(oJSON), "-X", "PUT")
+			resp, err = invokeCC("PUT", "/v2/organizations/"+guid, oJSON)
 			if err != nil {
-				showWarning(fmt.Sprintf("Could not update organization %s, exception message: %s",
-					org.Name, err.Error()))
+				return "", "", err
 			}
 			result, _, err = getResult(resp, "name", org.Name)
+			action = actionUpdated
 		}
 	}
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring organization %s: %s", org.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored organization %s", org.Name))
+		return "", "", err
 	}
-	return result
+	showInfo(fmt.Sprintf("Successfully restored organization %s", org.Name))
+	return result, action, nil
 }
 
-func restoreApp(app app) string {
+func restoreApp(app app) (string, error) {
 	oJSON, err := json.Marshal(app)
-	util.FreakOut(err)
+	if err != nil {
+		return "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/apps", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/apps", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create application %s, exception message: %s",
-			app.Name, err.Error()))
+		return "", err
 	}
 	result, _, err := getResult(resp, "name", app.Name)
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring application %s: %s", app.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored application %s", app.Name))
+		return "", err
 	}
-	return result
+	showInfo(fmt.Sprintf("Successfully restored application %s", app.Name))
+	return result, nil
 }
 
-func restoreFlag(flag models.FeatureFlagModel) string {
+func restoreFlag(flag models.FeatureFlagModel) error {
 	showInfo(fmt.Sprintf("Restoring Flag: %s", flag.Name))
 
 	var enabled string
@@ -244,169 +325,158 @@ func restoreFlag(flag models.FeatureFlagModel) string {
 
 	url := "/v2/config/feature_flags/" + flag.Name
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		url, "-H", "Content-Type: application/json",
-		"-d", string(pJSON), "-X", "PUT")
+	resp, err := invokeCC("PUT", url, []byte(pJSON))
 
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create flag %s, exception message: %s",
-			flag.Name, err.Error()))
+		return err
 	}
 
 	return showFlagResult(resp, flag)
 }
 
-func restoreQuota(quota quota) (string, error) {
+func restoreQuota(quota quota) (string, string, error) {
 
-	resources := util.GetResources(CliConnection, "/v2/quota_definitions?q=name:"+quota.Name, 1)
+	resources, err := util.GetResources(restoreCC, "/v2/quota_definitions?q=name:"+quota.Name, 1)
+	if err != nil {
+		return "", "", err
+	}
+	action := actionCreated
 	for _, u := range resources {
 		if u.Entity["name"].(string) == quota.Name {
 			showInfo(fmt.Sprintf("Deleting old quota %s", quota.Name))
 			err := deleteQuota(u.Metadata["guid"].(string))
 			if err != nil {
-				return "", err
+				return "", "", err
 			}
+			action = actionRecreated
 			break
 		}
 	}
 	showInfo(fmt.Sprintf("Restoring quota: %s - Guid=%s", quota.Name, quota.GUID))
 	oJSON, err := json.Marshal(quota)
-	util.FreakOut(err)
+	if err != nil {
+		return "", "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/quota_definitions", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/quota_definitions", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create quota %s, exception message: %s",
-			quota.Name, err.Error()))
+		return "", "", err
 	}
 
 	result, _, err := getResult(resp, "name", quota.Name)
-
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring quota %s: %s", quota.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored quota %s", quota.Name))
+		return "", "", err
 	}
-	return result, nil
+	showInfo(fmt.Sprintf("Successfully restored quota %s", quota.Name))
+	return result, action, nil
 }
 
-func restoreSpaceQuota(spacequota spacequota) (string, error) {
+func restoreSpaceQuota(spacequota spacequota) (string, string, error) {
 
-	resources := util.GetResources(CliConnection, "/v2/space_quota_definitions?q=name:"+spacequota.Name, 1)
-	if resources != nil {
-		for _, u := range resources {
-			if u.Entity["name"].(string) == spacequota.Name {
-				showInfo(fmt.Sprintf("Deleting old space quota %s", spacequota.Name))
-				err := deleteSpaceQuota(u.Metadata["guid"].(string))
-				if err != nil {
-					return "", err
-				}
-				break
+	// quota names are only unique within an org
+	query := "name:" + spacequota.Name + ";organization_guid:" + spacequota.OrganizationGUID
+	resources, err := util.GetResources(restoreCC, "/v2/space_quota_definitions?q="+query, 1)
+	if err != nil {
+		return "", "", err
+	}
+	action := actionCreated
+	for _, u := range resources {
+		if u.Entity["name"].(string) == spacequota.Name {
+			showInfo(fmt.Sprintf("Deleting old space quota %s", spacequota.Name))
+			err := deleteSpaceQuota(u.Metadata["guid"].(string))
+			if err != nil {
+				return "", "", err
 			}
+			action = actionRecreated
+			break
 		}
 	}
 	showInfo(fmt.Sprintf("Restoring space quota: %s", spacequota.Name))
 	oJSON, err := json.Marshal(spacequota)
-	util.FreakOut(err)
+	if err != nil {
+		return "", "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/space_quota_definitions", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/space_quota_definitions", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create quota %s, exception message: %s",
-			spacequota.Name, err.Error()))
+		return "", "", err
 	}
 
 	result, _, err := getResult(resp, "name", spacequota.Name)
-
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring quota %s: %s", spacequota.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored quota %s", spacequota.Name))
+		return "", "", err
 	}
-	return result, nil
+	showInfo(fmt.Sprintf("Successfully restored quota %s", spacequota.Name))
+	return result, action, nil
 }
 
-func restoreSpace(space space, orgGUID string) string {
+func restoreSpace(space space, orgGUID string) (string, string, error) {
 	showInfo(fmt.Sprintf("Restoring space: %s", space.Name))
 	oJSON, err := json.Marshal(space)
-	util.FreakOut(err)
+	if err != nil {
+		return "", "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/spaces", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/spaces", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create space %s, exception message: %s",
-			space.Name, err.Error()))
+		return "", "", err
 	}
 	result, obj, err := getResult(resp, "name", space.Name)
+	action := actionCreated
 	if err != nil && obj != nil && obj["error_code"] == "CF-SpaceNameTaken" {
 		// The space already exists; try to patch the existing one
 		guid := getGUIDByQuery("spaces", "name:"+space.Name, "organization_guid:"+orgGUID)
 		if guid != "" {
-			resp, err = CliConnection.CliCommandWithoutTerminalOutput("curl",
-				"/v2/spaces/"+guid, "-H", "Content-Type: application/json",
-				"-d", string(oJSON), "-X", "PUT")
+			resp, err = invokeCC("PUT", "/v2/spaces/"+guid, oJSON)
 			if err != nil {
-				showWarning(fmt.Sprintf("Could not update space %s, exception message: %s",
-					space.Name, err.Error()))
+				return "", "", err
 			}
 			result, _, err = getResult(resp, "name", space.Name)
+			action = actionUpdated
 		}
 	}
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring space %s: %s", space.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored space %s", space.Name))
+		return "", "", err
 	}
-	return result
+	showInfo(fmt.Sprintf("Successfully restored space %s", space.Name))
+	return result, action, nil
 }
 
-func showFlagResult(resp []string, flag models.FeatureFlagModel) string {
+func showFlagResult(resp []byte, flag models.FeatureFlagModel) error {
 	fResp := make(map[string]interface{})
 
-	err := json.Unmarshal([]byte(strings.Join(resp, "")), &fResp)
+	err := json.Unmarshal(resp, &fResp)
 
 	if err != nil {
-		showWarning(fmt.Sprintf("Got unknown response while restoring flag %s: %s",
-			flag.Name, err.Error()))
-		return ""
+		return fmt.Errorf("Got unknown response: %s", err.Error())
 	}
 
 	if fResp["error_code"] != nil {
-		showWarning(fmt.Sprintf("got %v while restoring flag %s",
-			fResp["error_code"], flag.Name))
-		return ""
+		return &ccError{Code: fmt.Sprint(fResp["error_code"]), Description: fmt.Sprint(fResp["description"])}
 	}
 
-	if fResp["name"] != nil {
-		inName := fResp["name"].(string)
-		if inName == flag.Name {
-			showInfo(fmt.Sprintf("Successfully restored flag %s", flag.Name))
-		} else {
-			showWarning(fmt.Sprintf("Name %s does not match requested name %s",
-				fResp["name"], flag.Name))
-		}
-	} else {
-		showWarning(fmt.Sprintln("\tWarning unknown answer received"))
+	if fResp["name"] == nil {
+		return fmt.Errorf("Warning unknown answer received")
 	}
-	return ""
+	if fResp["name"].(string) != flag.Name {
+		return fmt.Errorf("Name %s does not match requested name %s", fResp["name"], flag.Name)
+	}
+	showInfo(fmt.Sprintf("Successfully restored flag %s", flag.Name))
+	return nil
 }
 
 // getGUIDByName returns the GUID of the item of the specified type with the
 // given query; if not found, an empty string is returned.
 func getGUIDByQuery(itemType string, params ...string) string {
 	query := fmt.Sprintf("/v2/%s?q=%s", itemType, strings.Join(params, ";"))
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		query, "-H", "Content-Type: application/json", "-X", "GET")
+	resp, err := invokeCC("GET", query, nil)
 	if err != nil {
 		showWarning(fmt.Sprintf("Could not fetch %s %s, exception message: %s",
 			itemType, params[0], err.Error()))
 		return ""
 	}
 	var resources models.ResourceCollectionModel
-	err = json.Unmarshal([]byte(strings.Join(resp, "")), &resources)
+	err = json.Unmarshal(resp, &resources)
 	if err != nil {
 		showWarning(fmt.Sprintf("Could not fetch %s %s, exception message: %s",
 			itemType, params[0], err.Error()))
@@ -427,19 +497,33 @@ func getGUIDByQuery(itemType string, params ...string) string {
 	return ""
 }
 
+// invokeCC sends a request to the CC, returning CC errors as the body for getResult
+func invokeCC(method, path string, body []byte) ([]byte, error) {
+	resp, err := restoreCC.Invoke(method, path, body)
+	if _, failed := err.(*util.HTTPError); failed {
+		var ccErr struct {
+			ErrorCode interface{} `json:"error_code"`
+		}
+		if json.Unmarshal(resp, &ccErr) == nil && ccErr.ErrorCode != nil {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
 // getResult parses the response, and returns the guid if successful; otherwise,
 // it returns the parsed response object and an error.
-func getResult(resp []string, checkField, expectedValue string) (string, map[string]interface{}, error) {
+func getResult(resp []byte, checkField, expectedValue string) (string, map[string]interface{}, error) {
 	oResp := make(map[string]interface{})
 	if len(resp) == 0 {
 		return "", nil, fmt.Errorf("Got null response")
 	}
-	err := json.Unmarshal([]byte(strings.Join(resp, "")), &oResp)
+	err := json.Unmarshal(resp, &oResp)
 	if err != nil {
 		return "", nil, err
 	}
 	if oResp["error_code"] != nil {
-		return "", oResp, fmt.Errorf("Got %v-%v", oResp["error_code"], oResp["description"])
+		return "", oResp, &ccError{Code: fmt.Sprint(oResp["error_code"]), Description: fmt.Sprint(oResp["description"])}
 	}
 
 	if checkField != "" {
@@ -460,372 +544,1130 @@ func getResult(resp []string, checkField, expectedValue string) (string, map[str
 	return "", oResp, nil
 }
 
-func restoreSharedDomain(sharedDomain sharedDomain) (string, error) {
-	showInfo(fmt.Sprintf("Restoring shared domain: %s", sharedDomain.Name))
-	oJSON, err := json.Marshal(sharedDomain)
-	util.FreakOut(err)
+func getServicePlanGUID(plan servicePlanRef) (string, error) {
+	services, err := util.GetResources(restoreCC, "/v2/services?q=label:"+plan.Service, 1)
+	if err != nil {
+		return "", err
+	}
+	for _, service := range services {
+		if service.Entity["label"] != plan.Service {
+			continue
+		}
+		plans, err := util.GetResources(restoreCC, "/v2/service_plans?q=service_guid:"+service.Metadata["guid"].(string), 1)
+		if err != nil {
+			return "", err
+		}
+		for _, p := range plans {
+			if p.Entity["name"] == plan.Plan {
+				return p.Metadata["guid"].(string), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// waitForServiceInstance polls an asynchronously created service instance
+// until the broker finished provisioning it
+func waitForServiceInstance(guid, name string, obj map[string]interface{}) error {
+	deadline := time.Now().Add(serviceInstanceTimeout)
+	for {
+		entity, _ := obj["entity"].(map[string]interface{})
+		lastOperation, _ := entity["last_operation"].(map[string]interface{})
+		switch lastOperation["state"] {
+		case "failed":
+			return fmt.Errorf("provisioning failed: %v", lastOperation["description"])
+		case "in progress":
+		default:
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("provisioning did not finish within %v", serviceInstanceTimeout)
+		}
+		showInfo(fmt.Sprintf("Waiting for service instance %s to be provisioned", name))
+		time.Sleep(serviceInstancePollInterval)
+
+		resp, err := invokeCC("GET", "/v2/service_instances/"+guid, nil)
+		if err != nil {
+			return err
+		}
+		_, obj, err = getResult(resp, "name", name)
+		if err != nil {
+			return err
+		}
+	}
+}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/shared_domains", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+func restoreServiceInstance(instance serviceInstance) (string, string, error) {
+	showInfo(fmt.Sprintf("Restoring service instance: %s", instance.Name))
+	oJSON, err := json.Marshal(instance)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create shared domain %s, exception message: %s",
-			sharedDomain.Name, err.Error()))
+		return "", "", err
 	}
-	result, _, err := getResult(resp, "name", sharedDomain.Name)
+
+	resp, err := invokeCC("POST", "/v2/service_instances?accepts_incomplete=true", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring shared domain %s: %s", sharedDomain.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored shared domain %s", sharedDomain.Name))
+		return "", "", err
 	}
-	return result, nil
+	result, obj, err := getResult(resp, "name", instance.Name)
+	if err != nil && obj != nil && obj["error_code"] == "CF-ServiceInstanceNameTaken" {
+		// The instance already exists; keep it as it is and bind to it
+		showInfo(fmt.Sprintf("Service instance %s already exists", instance.Name))
+		return getGUIDByQuery("service_instances", "name:"+instance.Name, "space_guid:"+instance.SpaceGUID), actionSkipped, nil
+	}
+	if err == nil {
+		err = waitForServiceInstance(result, instance.Name, obj)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	showInfo(fmt.Sprintf("Successfully restored service instance %s", instance.Name))
+	return result, actionCreated, nil
 }
 
-func restoreQuotasWithGuids(backupObject *models.BackupModel,
-	quotaGuids *map[string]string) {
-	quotas := util.RestoreQuotaResourceModels(backupObject.OrgQuotas)
-	for _, quotaItem := range *quotas {
-		quotaJ := quota{Name: quotaItem.Entity["name"].(string),
-			NonBasicServicesAllowed: quotaItem.Entity["non_basic_services_allowed"].(bool),
-			TotalServices:           quotaItem.Entity["total_services"].(float64),
-			TotalRoutes:             quotaItem.Entity["total_routes"].(float64),
-			MemoryLimit:             quotaItem.Entity["memory_limit"].(float64),
-			GUID:                    quotaItem.Metadata["guid"].(string),
+func restoreUserProvidedServiceInstance(instance userProvidedServiceInstance) (string, string, error) {
+	showInfo(fmt.Sprintf("Restoring user provided service instance: %s", instance.Name))
+	oJSON, err := json.Marshal(instance)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := invokeCC("POST", "/v2/user_provided_service_instances", oJSON)
+	if err != nil {
+		return "", "", err
+	}
+	result, obj, err := getResult(resp, "name", instance.Name)
+	action := actionCreated
+	if err != nil && obj != nil && obj["error_code"] == "CF-ServiceInstanceNameTaken" {
+		// The instance already exists; try to patch the existing one
+		guid := getGUIDByQuery("user_provided_service_instances", "name:"+instance.Name, "space_guid:"+instance.SpaceGUID)
+		if guid != "" {
+			resp, err = invokeCC("PUT", "/v2/user_provided_service_instances/"+guid, oJSON)
+			if err != nil {
+				return "", "", err
+			}
+			result, _, err = getResult(resp, "name", instance.Name)
+			action = actionUpdated
 		}
+	}
+	if err != nil {
+		return "", "", err
+	}
+	showInfo(fmt.Sprintf("Successfully restored user provided service instance %s", instance.Name))
+	return result, action, nil
+}
+
+func restoreServiceKey(key serviceKey) (string, error) {
+	showInfo(fmt.Sprintf("Restoring service key: %s", key.Name))
+	oJSON, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := invokeCC("POST", "/v2/service_keys", oJSON)
+	if err != nil {
+		return "", err
+	}
+	result, _, err := getResult(resp, "name", key.Name)
+	if err != nil {
+		return "", err
+	}
+	showInfo(fmt.Sprintf("Successfully restored service key %s", key.Name))
+	return result, nil
+}
+
+func bindService(binding serviceBinding, appName string) (string, string, error) {
+	oJSON, err := json.Marshal(binding)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := invokeCC("POST", "/v2/service_bindings?accepts_incomplete=true", oJSON)
+	if err != nil {
+		return "", "", err
+	}
+	result, obj, err := getResult(resp, "app_guid", binding.AppGUID)
+	if err != nil && obj != nil && obj["error_code"] == "CF-ServiceBindingAppServiceTaken" {
+		showInfo(fmt.Sprintf("App %s is already bound to the service instance", appName))
+		return "", actionSkipped, nil
+	}
+	return result, actionCreated, err
+}
 
-		quotaRez, err := restoreQuota(quotaJ)
+// restoreSpaceServices re-creates the managed and user provided service
+// instances of a space, and the service keys of the managed ones
+func restoreSpaceServices(orgName string, sp models.Space, spaceGUID string,
+	userProvidedInstances *[]*models.ResourceModel,
+	servicePlans map[string]servicePlanRef,
+	serviceInstanceGuids map[string]string,
+	instanceFilter map[string]bool,
+	report *failureReport) {
+	for i, resource := range sp.ServiceInstances {
+		path := fmt.Sprintf("service_instances[%d]", i)
+		si, err := models.NewServiceInstance(path, resource)
+		if instanceFilter != nil && !instanceFilter[si.GUID] {
+			continue
+		}
+		entry := restoreEntry{
+			Type:    "service_instance",
+			Key:     orgName + "/" + sp.Name + "/" + nameOr(si.Name, path),
+			OldGUID: si.GUID,
+		}
 		if err != nil {
-			showWarning(fmt.Sprintf("Could not add quota %s, because: %s, organizations for this quota will be restored to the default quota", quotaJ.Name, err.Error()))
+			report.restored(entry, err)
+			continue
+		}
+		if !report.resumed(&entry) {
+			plan, found := servicePlans[si.ServicePlanGUID]
+			if !found {
+				report.restored(entry, fmt.Errorf("Service plan is not in the backup"))
+				continue
+			}
+			planGUID, err := getServicePlanGUID(plan)
+			if err == nil && planGUID == "" {
+				err = fmt.Errorf("Service plan %s of service %s not found", plan.Plan, plan.Service)
+			}
+			if err != nil {
+				report.restored(entry, err)
+				continue
+			}
+
+			entry.NewGUID, entry.Action, err = restoreServiceInstance(serviceInstance{
+				Name:            si.Name,
+				SpaceGUID:       spaceGUID,
+				ServicePlanGUID: planGUID,
+				Parameters:      si.Parameters,
+				Tags:            si.Tags,
+			})
+			if !report.restored(entry, err) {
+				continue
+			}
+		}
+		if entry.NewGUID == "" {
+			continue
+		}
+		serviceInstanceGuids[entry.OldGUID] = entry.NewGUID
+
+		for _, key := range si.Keys {
+			keyEntry := restoreEntry{
+				Type:    "service_key",
+				Key:     entry.Key + "/" + key.Name,
+				OldGUID: key.GUID,
+				Action:  actionCreated,
+			}
+			if report.resumed(&keyEntry) {
+				continue
+			}
+			var err error
+			keyEntry.NewGUID, err = restoreServiceKey(serviceKey{Name: key.Name, ServiceInstanceGUID: entry.NewGUID})
+			report.restored(keyEntry, err)
 		}
-		(*quotaGuids)[quotaItem.Metadata["guid"].(string)] = quotaRez
 	}
-}
 
-func restoreSpaceQuotasWithGuids(backupObject *models.BackupModel,
-	spaceQuotaGuids *map[string]string,
-	oldOrgGUID string, newOrgGUID string) {
-	if backupObject.SpaceQuotas != nil {
-		quotas := util.RestoreSpaceQuotaResourceModels(backupObject.SpaceQuotas)
-		for _, quotaItem := range *quotas {
-			if quotaItem.Entity["organization_guid"].(string) == oldOrgGUID {
-				quotaJ := spacequota{Name: quotaItem.Entity["name"].(string),
-					NonBasicServicesAllowed: quotaItem.Entity["non_basic_services_allowed"].(bool),
-					TotalServices:           quotaItem.Entity["total_services"].(float64),
-					TotalRoutes:             quotaItem.Entity["total_routes"].(float64),
-					MemoryLimit:             quotaItem.Entity["memory_limit"].(float64),
-					OrganizationGUID:        newOrgGUID,
+	if userProvidedInstances != nil {
+		for i, resource := range *userProvidedInstances {
+			if resource == nil || resource.Entity == nil || resource.Entity["space_guid"] != sp.GUID {
+				continue
+			}
+			path := fmt.Sprintf("user_provided_service_instances[%d]", i)
+			ups, err := models.NewUserProvidedServiceInstance(path, resource)
+			if instanceFilter != nil && !instanceFilter[ups.GUID] {
+				continue
+			}
+			entry := restoreEntry{
+				Type:    "user_provided_service_instance",
+				Key:     orgName + "/" + sp.Name + "/" + nameOr(ups.Name, path),
+				OldGUID: ups.GUID,
+			}
+			if err != nil {
+				report.restored(entry, err)
+				continue
+			}
+			if !report.resumed(&entry) {
+				entry.NewGUID, entry.Action, err = restoreUserProvidedServiceInstance(userProvidedServiceInstance{
+					Name:            ups.Name,
+					SpaceGUID:       spaceGUID,
+					Credentials:     ups.Credentials,
+					SyslogDrainURL:  ups.SyslogDrainURL,
+					RouteServiceURL: ups.RouteServiceURL,
+					Tags:            ups.Tags,
+				})
+				if !report.restored(entry, err) {
+					continue
 				}
+			}
+			serviceInstanceGuids[entry.OldGUID] = entry.NewGUID
+		}
+	}
+}
 
-				quotaRez, err := restoreSpaceQuota(quotaJ)
+// nameOr returns name, or path when the name of a bad backup entry could
+// not be read
+func nameOr(name, path string) string {
+	if name == "" {
+		return path
+	}
+	return name
+}
+
+// findBuildpack returns the existing buildpack with the same name and stack
+func findBuildpack(name string, stack interface{}) (*models.ResourceModel, error) {
+	resources, err := util.GetResources(restoreCC, "/v2/buildpacks?q=name:"+name, 1)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range resources {
+		if u.Entity["name"] == name && (stack == nil || u.Entity["stack"] == nil || u.Entity["stack"] == stack) {
+			return u, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func createBuildpack(buildpack buildpack) (string, error) {
+	showInfo(fmt.Sprintf("Creating buildpack: %s", buildpack.Name))
+	oJSON, err := json.Marshal(buildpack)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := invokeCC("POST", "/v2/buildpacks", oJSON)
+	if err != nil {
+		return "", err
+	}
+	result, _, err := getResult(resp, "name", buildpack.Name)
+	return result, err
+}
+
+func updateBuildpack(guid string, buildpack buildpack) error {
+	oJSON, err := json.Marshal(buildpack)
+	if err != nil {
+		return err
+	}
+
+	resp, err := invokeCC("PUT", "/v2/buildpacks/"+guid, oJSON)
+	if err != nil {
+		return err
+	}
+	_, _, err = getResult(resp, "name", buildpack.Name)
+	return err
+}
+
+// selectedBuildpacks returns the buildpacks of the backup passing
+// buildpackFilter, all of them without a filter
+func selectedBuildpacks(backupObject *models.BackupModel, buildpackFilter map[string]bool) []*models.ResourceModel {
+	if backupObject.Buildpacks == nil {
+		return nil
+	}
+
+	var buildpacks []*models.ResourceModel
+	for _, bp := range *util.RestoreBuildpackResourceModels(backupObject.Buildpacks) {
+		if buildpackFilter == nil || buildpackFilter[bp.Entity["name"].(string)] {
+			buildpacks = append(buildpacks, bp)
+		}
+	}
+	return buildpacks
+}
+
+// restoreBuildpacks re-creates the buildpacks in position order, on the
+// stacks they are mapped to, uploads their bits and re-applies position,
+// enabled and locked
+func restoreBuildpacks(buildpacks []*models.ResourceModel, stacks stackMap, report *failureReport) {
+	sort.SliceStable(buildpacks, func(i, j int) bool {
+		pi, _ := buildpacks[i].Entity["position"].(float64)
+		pj, _ := buildpacks[j].Entity["position"].(float64)
+		return pi < pj
+	})
+
+	packager := newRestorePackager()
+
+	for _, bp := range buildpacks {
+		name := bp.Entity["name"].(string)
+		entry := restoreEntry{Type: "buildpack", Key: name, OldGUID: bp.Metadata["guid"].(string)}
+		if report.resumed(&entry) {
+			continue
+		}
+		showInfo(fmt.Sprintf("Restoring buildpack: %s", name))
+
+		stack := bp.Entity["stack"]
+		if name, ok := stack.(string); ok {
+			stack = stacks.target(name)
+		}
+		var currentFilename interface{}
+		existing, err := findBuildpack(name, stack)
+		if existing != nil {
+			entry.NewGUID = existing.Metadata["guid"].(string)
+			entry.Action = actionUpdated
+			currentFilename = existing.Entity["filename"]
+			if existing.Entity["locked"] == true && bp.Entity["filename"] != nil && bp.Entity["filename"] != currentFilename {
+				err := updateBuildpack(entry.NewGUID, buildpack{Name: name, Locked: false})
 				if err != nil {
-					showWarning(fmt.Sprintf("Could not add quota %s, because: %s, organizations for this quota will be restored to the default quota", quotaJ.Name, err.Error()))
+					showWarning(fmt.Sprintf("Could not unlock buildpack %s: %s", name, err.Error()))
+				}
+			}
+		} else if err == nil {
+			entry.Action = actionCreated
+			entry.NewGUID, err = createBuildpack(buildpack{Name: name, Stack: stack})
+		}
+		if err != nil {
+			report.restored(entry, err)
+			continue
+		}
+
+		if filename, ok := bp.Entity["filename"].(string); ok && filename != currentFilename {
+			zipPath := filepath.Join(backupBuildpacksDir, entry.OldGUID+".zip")
+			err := packager.UploadBuildpack(entry.NewGUID, zipPath, filename)
+			bitsEntry := entry
+			bitsEntry.Type, bitsEntry.Action = "buildpack_bits", actionUpdated
+			report.restored(bitsEntry, err)
+		}
+
+		err = updateBuildpack(entry.NewGUID, buildpack{
+			Name:     name,
+			Position: bp.Entity["position"],
+			Enabled:  bp.Entity["enabled"],
+			Locked:   bp.Entity["locked"],
+		})
+		if report.restored(entry, err) {
+			showInfo(fmt.Sprintf("Successfully restored buildpack %s", name))
+		}
+	}
+}
+
+func restoreSharedDomain(sharedDomain sharedDomain) (string, string, error) {
+	showInfo(fmt.Sprintf("Restoring shared domain: %s", sharedDomain.Name))
+	oJSON, err := json.Marshal(sharedDomain)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := invokeCC("POST", "/v2/shared_domains", oJSON)
+	if err != nil {
+		return "", "", err
+	}
+	result, obj, err := getResult(resp, "name", sharedDomain.Name)
+	if err != nil && obj != nil && obj["error_code"] == "CF-DomainNameTaken" {
+		// The target has this domain already, e.g. its own apps domain
+		showInfo(fmt.Sprintf("Shared domain %s already exists", sharedDomain.Name))
+		guid := getGUIDByQuery("shared_domains", "name:"+sharedDomain.Name)
+		if guid == "" {
+			return "", "", fmt.Errorf("Could not find the existing shared domain %s", sharedDomain.Name)
+		}
+		return guid, actionSkipped, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	showInfo(fmt.Sprintf("Successfully restored shared domain %s", sharedDomain.Name))
+	return result, actionCreated, nil
+}
+
+func restoreQuotasWithGuids(quotas []models.Quota,
+	quotaGuids *map[string]string, quotaFilter map[string]bool, report *failureReport) {
+	for _, quotaItem := range quotas {
+		if quotaFilter != nil && !quotaFilter[quotaItem.GUID] {
+			continue
+		}
+		quotaJ := quota{Name: quotaItem.Name,
+			NonBasicServicesAllowed: quotaItem.NonBasicServicesAllowed,
+			TotalServices:           quotaItem.TotalServices,
+			TotalRoutes:             quotaItem.TotalRoutes,
+			MemoryLimit:             quotaItem.MemoryLimit,
+			TotalReservedRoutePorts: quotaItem.TotalReservedRoutePorts,
+			TotalServiceKeys:        quotaItem.TotalServiceKeys,
+			InstanceMemoryLimit:     quotaItem.InstanceMemoryLimit,
+			AppInstanceLimit:        quotaItem.AppInstanceLimit,
+			GUID:                    quotaItem.GUID,
+		}
+
+		entry := restoreEntry{Type: "org_quota", Key: quotaJ.Name, OldGUID: quotaItem.GUID}
+		if !report.resumed(&entry) {
+			var err error
+			entry.NewGUID, entry.Action, err = restoreQuota(quotaJ)
+			if !report.restored(entry, err) {
+				showWarning(fmt.Sprintf("Organizations for quota %s will be restored to the default quota", quotaJ.Name))
+			}
+		}
+		(*quotaGuids)[quotaItem.GUID] = entry.NewGUID
+	}
+}
+
+func restoreSpaceQuotasWithGuids(spaceQuotas []models.Quota,
+	spaceQuotaGuids *map[string]string, orgName string,
+	oldOrgGUID string, newOrgGUID string, quotaFilter map[string]bool, report *failureReport) {
+	for _, quotaItem := range spaceQuotas {
+		if quotaFilter != nil && !quotaFilter[quotaItem.GUID] {
+			continue
+		}
+		if quotaItem.OrganizationGUID == oldOrgGUID {
+			quotaJ := spacequota{Name: quotaItem.Name,
+				NonBasicServicesAllowed: quotaItem.NonBasicServicesAllowed,
+				TotalServices:           quotaItem.TotalServices,
+				TotalRoutes:             quotaItem.TotalRoutes,
+				MemoryLimit:             quotaItem.MemoryLimit,
+				TotalReservedRoutePorts: quotaItem.TotalReservedRoutePorts,
+				TotalServiceKeys:        quotaItem.TotalServiceKeys,
+				InstanceMemoryLimit:     quotaItem.InstanceMemoryLimit,
+				AppInstanceLimit:        quotaItem.AppInstanceLimit,
+				OrganizationGUID:        newOrgGUID,
+			}
+
+			// Space quota names are only unique within their org
+			entry := restoreEntry{Type: "space_quota", Key: orgName + "/" + quotaJ.Name, OldGUID: quotaItem.GUID}
+			if !report.resumed(&entry) {
+				var err error
+				entry.NewGUID, entry.Action, err = restoreSpaceQuota(quotaJ)
+				if !report.restored(entry, err) {
+					showWarning(fmt.Sprintf("Spaces for quota %s will be restored without a space quota", quotaJ.Name))
 				}
-				(*spaceQuotaGuids)[quotaItem.Metadata["guid"].(string)] = quotaRez
 			}
+			(*spaceQuotaGuids)[quotaItem.GUID] = entry.NewGUID
 		}
 	}
 }
 
-func restoreFromJSON(includeSecurityGroups bool, includeQuotaDefinitions bool) {
+func newRestorePackager() util.Packager {
+	if conn, planning := restoreCC.(*dryRunConnection); planning {
+		return &dryRunPackager{conn: conn}
+	}
+
+	return &util.CFPackager{
+		Cli:    CliConnection,
+		Writer: new(util.CFFileWriter),
+		Reader: new(util.CFFileReader),
+	}
+}
+
+// reportSkipped records the selected apps and routes the backup could not
+// hold as failed, as they are not restored
+func reportSkipped(skipped []*models.SkippedResource, selector *restoreSelector, report *failureReport) {
+	for _, resource := range skipped {
+		if selector.appSelected(resource.Organization, resource.Space, resource.App) {
+			report.restored(restoreEntry{Type: resource.Kind, Key: resource.Key()}, resource)
+		}
+	}
+}
+
+func restoreFromJSON(options restoreOptions, report *failureReport) error {
+	includeSecurityGroups := options.includeSecurityGroups
+	includeQuotaDefinitions := options.includeQuotaDefinitions
 
 	//map["old_guid"] = "new_guid"
 	spaceGuids := make(map[string]string)
 
 	fileContent, err := ioutil.ReadFile(backupFile)
 	if os.IsNotExist(err) {
-		fmt.Fprintf(os.Stdout, "Failed to read backup information file %s.\nYou can create one with `backup-snapshot`.\n", backupFile)
-		os.Exit(1)
+		return fmt.Errorf("Failed to read backup information file %s.\nYou can create one with `backup-snapshot`.", backupFile)
+	}
+	if err != nil {
+		return err
 	}
-	util.FreakOut(err)
 
 	backupObject, err := util.ReadBackupJSON(fileContent)
-	util.FreakOut(err)
+	if err != nil {
+		return err
+	}
+
+	ccAPI, err := util.NewHTTPCCApi(CliConnection)
+	if err != nil {
+		return err
+	}
+	restoreCC = ccAPI
 
-	ccResources := util.CreateSharedDomainsCCResources(nil)
-	sharedDomains := ccResources.TransformToResourceModels(backupObject.SharedDomains)
+	checkBackupOrigin(backupObject.Metadata)
+	if options.dryRun {
+		conn := newDryRunConnection(ccAPI)
+		restoreCC = conn
+		defer conn.plan.print(os.Stdout)
+	}
 
-	for _, sd := range *sharedDomains {
-		sharedDomain := sharedDomain{Name: sd.Entity["name"].(string)}
-		restoreSharedDomain(sharedDomain)
+	orgs, err := util.RestoreOrganizations(backupObject.Organizations)
+	skippedErr, partial := err.(*models.SkippedError)
+	if err != nil && !partial {
+		return err
+	}
+	sharedDomains, err := util.RestoreSharedDomains(backupObject.SharedDomains)
+	if err != nil {
+		return err
+	}
+	orgQuotas, err := util.RestoreQuotas(backupObject.OrgQuotas)
+	if err != nil {
+		return err
+	}
+	spaceQuotas, err := util.RestoreSpaceQuotas(backupObject.SpaceQuotas)
+	if err != nil {
+		return err
+	}
+	var securityGroups []models.SecurityGroup
+	if includeSecurityGroups {
+		securityGroups, err = util.RestoreSecurityGroups(backupObject.SecurityGroups)
+		if err != nil {
+			return err
+		}
+	}
+
+	selector := options.selector
+	deps := selector.dependencies(orgs)
+
+	if partial {
+		reportSkipped(skippedErr.Skipped, selector, report)
+	}
+
+	for _, sd := range sharedDomains {
+		if deps != nil && !deps.sharedDomains[sd.Name] {
+			continue
+		}
+		name := options.domains.target(sd.Name)
+		entry := restoreEntry{Type: "shared_domain", Key: name, OldGUID: sd.GUID, Action: actionCreated}
+		if report.resumed(&entry) {
+			continue
+		}
+		if name != sd.Name {
+			showInfo(fmt.Sprintf("Restoring shared domain %s as %s", sd.Name, name))
+		}
+		var err error
+		entry.NewGUID, entry.Action, err = restoreSharedDomain(sharedDomain{Name: name})
+		report.restored(entry, err)
 	}
 
-	orgs := util.RestoreOrgResourceModels(backupObject.Organizations)
-	featureflags := util.RestoreFlagsResourceModels(backupObject.FeatureFlags)
+	if deps == nil {
+		featureflags := util.RestoreFlagsResourceModels(backupObject.FeatureFlags)
 
-	for _, flagobj := range *featureflags {
-		restoreFlag(*flagobj)
+		for _, flagobj := range *featureflags {
+			entry := restoreEntry{Type: "feature_flag", Key: flagobj.Name, Action: actionUpdated}
+			if !report.resumed(&entry) {
+				report.restored(entry, restoreFlag(*flagobj))
+			}
+		}
 	}
 
+	var buildpackFilter map[string]bool
+	if deps != nil {
+		buildpackFilter = deps.buildpacks
+	}
+	buildpacks := selectedBuildpacks(backupObject, buildpackFilter)
+	if err := checkStacks(orgs, buildpacks, selector, options.stacks); err != nil {
+		showWarning(fmt.Sprintf("Could not check the stacks of the target: %s", err.Error()))
+	}
+	restoreBuildpacks(buildpacks, options.stacks, report)
+
 	quotaGuids := make(map[string]string)
 	spaceQuotaGuids := make(map[string]string)
+	serviceInstanceGuids := make(map[string]string)
+	var pendingApps []*pendingApp
+
+	servicePlans := make(map[string]servicePlanRef)
+	if backupObject.ServicePlans != nil {
+		for _, plan := range *util.RestoreServicePlanResourceModels(backupObject.ServicePlans) {
+			if service, ok := plan.Entity["service"].(*models.ResourceModel); ok && service.Entity != nil {
+				servicePlans[plan.Metadata["guid"].(string)] = servicePlanRef{
+					Service: service.Entity["label"].(string),
+					Plan:    plan.Entity["name"].(string),
+				}
+			}
+		}
+	}
+	var userProvidedInstances *[]*models.ResourceModel
+	if backupObject.UserProvidedServiceInstances != nil {
+		userProvidedInstances = util.RestoreUserProvidedServiceInstanceResourceModels(backupObject.UserProvidedServiceInstances)
+	}
+
+	var quotaFilter, spaceQuotaFilter map[string]bool
+	if deps != nil {
+		quotaFilter, spaceQuotaFilter = deps.quotas, deps.spaceQuotas
+	}
 
 	if includeQuotaDefinitions {
-		restoreQuotasWithGuids(backupObject, &quotaGuids)
+		restoreQuotasWithGuids(orgQuotas, &quotaGuids, quotaFilter, report)
 	}
-	if orgs != nil {
-		for _, organization := range *orgs {
-			var o org
-			if includeQuotaDefinitions && quotaGuids[organization.Entity["quota_definition_guid"].(string)] != "" {
-				o = org{Name: organization.Entity["name"].(string), QuotaGUID: quotaGuids[organization.Entity["quota_definition_guid"].(string)]}
-			} else {
-				o = org{Name: organization.Entity["name"].(string)}
+	for _, organization := range orgs {
+		if !selector.orgTouched(organization.Name) {
+			continue
+		}
+
+		o := org{Name: organization.Name}
+		if includeQuotaDefinitions {
+			o.QuotaGUID = quotaGuids[organization.QuotaGUID]
+		}
+		orgEntry := restoreEntry{Type: "organization", Key: organization.Name, OldGUID: organization.GUID}
+		if !report.resumed(&orgEntry) {
+			orgEntry.NewGUID, orgEntry.Action, err = restoreOrg(o)
+			if !report.restored(orgEntry, err) {
+				continue
 			}
-			orgGUID := restoreOrg(o)
+		}
+		orgGUID := orgEntry.NewGUID
 
-			if includeQuotaDefinitions {
-				restoreSpaceQuotasWithGuids(backupObject, &spaceQuotaGuids, organization.Metadata["guid"].(string), orgGUID)
+		if includeQuotaDefinitions {
+			restoreSpaceQuotasWithGuids(spaceQuotas, &spaceQuotaGuids, organization.Name, organization.GUID, orgGUID, spaceQuotaFilter, report)
+		}
+
+		// Roles are keyed by org[/space], role and username
+		restoreRole := func(user models.UserRole, target, targetKey, role string) {
+			entry := restoreEntry{Type: "user_role", Key: targetKey + " " + role + " " + user.Username, OldGUID: user.GUID, Action: actionCreated}
+			if !report.resumed(&entry) {
+				report.restored(entry, restoreUserRole(user.Username, target, role))
 			}
+		}
 
-			if orgGUID != "" {
-				if organization.Entity["auditors"] != nil {
-					auditors := organization.Entity["auditors"].(*[]*models.ResourceModel)
-					for _, auditor := range *auditors {
-						restoreUserRole(auditor.Entity["username"].(string), orgGUID, orgDev)
-						restoreUserRole(auditor.Entity["username"].(string), orgGUID, orgAudit)
-					}
+		// The org roles and private domains only come with the whole org;
+		// a narrower restore gets the private domains its routes use
+		orgSelected := selector.orgSelected(organization.Name)
+		if orgSelected {
+			for _, auditor := range organization.Auditors {
+				restoreRole(auditor, orgGUID, organization.Name, orgDev)
+				restoreRole(auditor, orgGUID, organization.Name, orgAudit)
+			}
+			for _, manager := range organization.BillingManagers {
+				restoreRole(manager, orgGUID, organization.Name, orgDev)
+				restoreRole(manager, orgGUID, organization.Name, orgBilling)
+			}
+			for _, manager := range organization.Managers {
+				restoreRole(manager, orgGUID, organization.Name, orgDev)
+				restoreRole(manager, orgGUID, organization.Name, orgManager)
+			}
+		}
+
+		for _, domain := range organization.PrivateDomains {
+			if !orgSelected && !deps.privateDomains[domain.GUID] {
+				continue
+			}
+			name := options.domains.target(domain.Name)
+			entry := restoreEntry{Type: "private_domain", Key: name, OldGUID: domain.GUID, Action: actionCreated}
+			if report.resumed(&entry) {
+				continue
+			}
+			if name != domain.Name {
+				showInfo(fmt.Sprintf("Restoring private domain %s as %s", domain.Name, name))
+			}
+			entry.NewGUID, entry.Action, err = restorePrivateDomain(privateDomain{Name: name, OwningOrganizationGUID: orgGUID})
+			report.restored(entry, err)
+		}
+
+		for _, sp := range organization.Spaces {
+			if !selector.spaceTouched(organization.Name, sp.Name) {
+				continue
+			}
+
+			s := space{Name: sp.Name, OrganizationGUID: orgGUID}
+			if includeQuotaDefinitions && sp.SpaceQuotaGUID != "" {
+				s.SpaceQuotaGUID = spaceQuotaGuids[sp.SpaceQuotaGUID]
+			}
+			spaceKey := organization.Name + "/" + sp.Name
+			spaceEntry := restoreEntry{Type: "space", Key: spaceKey, OldGUID: sp.GUID}
+			if !report.resumed(&spaceEntry) {
+				spaceEntry.NewGUID, spaceEntry.Action, err = restoreSpace(s, orgGUID)
+				if !report.restored(spaceEntry, err) {
+					continue
 				}
+			}
+			spaceGUID := spaceEntry.NewGUID
+			spaceGuids[sp.GUID] = spaceGUID
 
-				if organization.Entity["billing_managers"] != nil {
-					billingManagers := organization.Entity["billing_managers"].(*[]*models.ResourceModel)
-					for _, manager := range *billingManagers {
-						restoreUserRole(manager.Entity["username"].(string), orgGUID, orgDev)
-						restoreUserRole(manager.Entity["username"].(string), orgGUID, orgBilling)
-					}
+			for _, auditor := range sp.Auditors {
+				restoreRole(auditor, spaceGUID, spaceKey, spaceAudit)
+			}
+			for _, developer := range sp.Developers {
+				restoreRole(developer, spaceGUID, spaceKey, spaceDev)
+			}
+			for _, manager := range sp.Managers {
+				restoreRole(manager, spaceGUID, spaceKey, spaceManager)
+			}
+
+			var instanceFilter map[string]bool
+			if !selector.spaceSelected(organization.Name, sp.Name) {
+				instanceFilter = deps.serviceInstances
+			}
+			restoreSpaceServices(organization.Name, sp, spaceGUID, userProvidedInstances, servicePlans, serviceInstanceGuids, instanceFilter, report)
+
+			pendingApps = append(pendingApps, restoreSpaceApps(organization, sp, spaceGUID, serviceInstanceGuids, options, report)...)
+		}
+	}
+	droplets := backupObject.Droplets
+	if options.restage {
+		droplets = nil
+	}
+	startedApps := startApps(pendingApps, droplets, report)
+	if options.wait && !options.dryRun {
+		waitForApps(startedApps, options.waitTimeout, report)
+	}
+
+	for _, sg := range securityGroups {
+		if deps != nil && !boundToSpaces(sg.Spaces, deps.spaces) {
+			continue
+		}
+
+		var newSpaces []string
+		if sg.Spaces != nil {
+			newSpaces = make([]string, 0, len(sg.Spaces))
+			for _, s := range sg.Spaces {
+				spaceGUID, restored := spaceGuids[s.GUID]
+				if !restored {
+					// Keep the binding of spaces left out of this restore
+					spaceGUID = getLiveSpaceGUID(s)
+				}
+				if spaceGUID == "" {
+					showWarning(fmt.Sprintf("Space %s not found. Not binding it to security group %s", s.Name, sg.Name))
+					continue
 				}
+				newSpaces = append(newSpaces, spaceGUID)
+			}
+		}
 
-				if organization.Entity["managers"] != nil {
-					managers := organization.Entity["managers"].(*[]*models.ResourceModel)
-					for _, manager := range *managers {
-						restoreUserRole(manager.Entity["username"].(string), orgGUID, orgDev)
-						restoreUserRole(manager.Entity["username"].(string), orgGUID, orgManager)
-					}
+		g := securityGroup{
+			Name:           sg.Name,
+			Rules:          sg.Rules,
+			SpaceGuids:     newSpaces,
+			RunningDefault: sg.RunningDefault,
+			StagingDefault: sg.StagingDefault,
+		}
+
+		entry := restoreEntry{Type: "security_group", Key: g.Name, OldGUID: sg.GUID}
+		if report.resumed(&entry) {
+			continue
+		}
+		entry.NewGUID, entry.Action, err = restoreSecurityGroup(g)
+		report.restored(entry, err)
+	}
+
+	// The resources left once the token could not be refreshed all failed
+	return ccAPI.AuthError()
+}
+
+// restoreSpaceApps restores the apps of a space selected by options with
+// their service bindings and routes, under the mapped domains. It returns
+// the apps waiting for their bits.
+func restoreSpaceApps(organization models.Organization, sp models.Space, spaceGUID string,
+	serviceInstanceGuids map[string]string, options restoreOptions, report *failureReport) []*pendingApp {
+	selector := options.selector
+	var pending []*pendingApp
+	appsCount := 0
+	for _, application := range sp.Apps {
+		if selector.appSelected(organization.Name, sp.Name, application.Name) {
+			appsCount++
+		}
+	}
+	appIndex := 1
+
+	for _, application := range sp.Apps {
+		if !selector.appSelected(organization.Name, sp.Name, application.Name) {
+			continue
+		}
+
+		appEntry := restoreEntry{
+			Type:    "app",
+			Key:     organization.Name + "/" + sp.Name + "/" + application.Name,
+			OldGUID: application.GUID,
+			Action:  actionCreated,
+		}
+
+		showInfo(fmt.Sprintf("Restoring App %s for space %s [%d/%d]", application.Name, sp.Name, appIndex, appsCount))
+		appIndex++
+
+		if !report.resumed(&appEntry) {
+			restored := restoreAppWithBindings(application, spaceGUID, appEntry, serviceInstanceGuids, options.stacks, report)
+			if restored == nil {
+				continue
+			}
+			pending = append(pending, restored)
+			appEntry = restored.entry
+		}
+		appGUID := appEntry.NewGUID
+
+		boundRoute := false
+		for _, rt := range application.Routes {
+			rt.Domain.Name = options.domains.target(rt.Domain.Name)
+			routeEntry := restoreEntry{Type: "route", Key: rt.Key(), OldGUID: rt.GUID}
+			if !report.resumed(&routeEntry) {
+				r := route{
+					SpaceGUID: spaceGUID,
+					Port:      rt.Port,
+					Path:      rt.Path,
+					Host:      rt.Host,
 				}
 
-				if organization.Entity["private_domains"] != nil {
-					privateDomains := organization.Entity["private_domains"].(*[]*models.ResourceModel)
-					for _, domain := range *privateDomains {
-						pd := privateDomain{Name: domain.Entity["name"].(string), OwningOrganizationGUID: orgGUID}
-						restorePrivateDomain(pd)
+				domainName := rt.Domain.Name
+				var err error
+				if rt.Domain.Shared() {
+					r.DomainGUID, err = getSharedDomainGUID(domainName)
+					if err == nil && r.DomainGUID == "" {
+						err = fmt.Errorf("Could not find shared domain %s", domainName)
 					}
+				} else {
+					r.DomainGUID, err = getPrivateDomainGUID(domainName)
+					if err == nil && r.DomainGUID == "" {
+						err = fmt.Errorf("Could not find private domain %s", domainName)
+					}
+				}
+				if err == nil {
+					routeEntry.NewGUID, routeEntry.Action, err = createRoute(r)
 				}
+				if !report.restored(routeEntry, err) {
+					continue
+				}
+			}
+			mappingEntry := restoreEntry{Type: "route_mapping", Key: routeEntry.Key + " " + appEntry.Key, Action: actionCreated}
+			if report.resumed(&mappingEntry) {
+				boundRoute = true
+				continue
+			}
+			showInfo(fmt.Sprintf("Binding route %s to app %s", routeEntry.Key, application.Name))
+			err := bindRoute(appGUID, routeEntry.NewGUID)
+			if report.restored(mappingEntry, err) {
+				boundRoute = true
+				showInfo(fmt.Sprintf("Successfully bound route %s to app %s", routeEntry.Key, application.Name))
+			}
+		}
 
-				if organization.Entity["spaces"] != nil {
-					spaces := organization.Entity["spaces"].(*[]*models.ResourceModel)
-					for _, sp := range *spaces {
-						s := space{Name: sp.Entity["name"].(string), OrganizationGUID: orgGUID}
-						if includeQuotaDefinitions && sp.Entity["space_quota_definition_guid"] != nil {
-							s.SpaceQuotaGUID = spaceQuotaGuids[sp.Entity["space_quota_definition_guid"].(string)]
-						}
-						spaceGUID := restoreSpace(s, orgGUID)
-						spaceGuids[sp.Metadata["guid"].(string)] = spaceGUID
-
-						if spaceGUID != "" {
-							if sp.Entity["auditors"] != nil {
-								auditors := sp.Entity["auditors"].(*[]*models.ResourceModel)
-								for _, auditor := range *auditors {
-									restoreUserRole(auditor.Entity["username"].(string), spaceGUID, spaceAudit)
-								}
-							}
-
-							if sp.Entity["developers"] != nil {
-								developers := sp.Entity["developers"].(*[]*models.ResourceModel)
-								for _, developer := range *developers {
-									restoreUserRole(developer.Entity["username"].(string), spaceGUID, spaceDev)
-								}
-							}
-
-							if sp.Entity["managers"] != nil {
-								managers := sp.Entity["managers"].(*[]*models.ResourceModel)
-								for _, manager := range *managers {
-									restoreUserRole(manager.Entity["username"].(string), spaceGUID, spaceManager)
-								}
-							}
-						}
-
-						//continue if there are no apps to restore
-						if sp.Entity["apps"] == nil {
-							continue
-						}
-
-						apps := sp.Entity["apps"].(*[]*models.ResourceModel)
-						packager := &util.CFPackager{
-							Cli:    CliConnection,
-							Writer: new(util.CFFileWriter),
-							Reader: new(util.CFFileReader),
-						}
-						appBits := util.NewCFDroplet(CliConnection, packager)
-
-						appsCount := len(*apps)
-						appIndex := 1
-
-						for _, application := range *apps {
-							stackName := application.Entity["stack"].(*models.ResourceModel).Entity["name"].(string)
-							stackGUID := getStackGUID(stackName)
-							if application.Entity["docker_image"] != nil {
-								// When docker image, we have to pretend the app has no stack
-								stackGUID = ""
-							} else if stackGUID == "" {
-								showWarning(fmt.Sprintf("Stack %s not found. Skipping app %s", stackName, application.Entity["name"].(string)))
-								continue
-							}
-
-							a := app{
-								Name:               application.Entity["name"].(string),
-								SpaceGUID:          spaceGUID,
-								Diego:              application.Entity["diego"],
-								Memory:             application.Entity["memory"],
-								Instances:          application.Entity["instances"],
-								DiskQuota:          application.Entity["disk_quota"],
-								StackGUID:          stackGUID,
-								Command:            application.Entity["command"],
-								Buildpack:          application.Entity["buildpack"],
-								HealthCheckType:    application.Entity["health_check_type"],
-								HealthCheckTimeout: application.Entity["health_check_timeout"],
-								EnableSSH:          application.Entity["enable_ssh"],
-								DockerImage:        application.Entity["docker_image"],
-								EnvironmentJSON:    application.Entity["environment_json"],
-							}
-							if ports, ok := application.Entity["ports"].([]interface{}); ok && len(ports) > 0 {
-								a.Ports = ports
-							}
-
-							showInfo(fmt.Sprintf("Restoring App %s for space %s [%d/%d]", a.Name, sp.Entity["name"].(string), appIndex, appsCount))
-
-							appGUID := restoreApp(a)
-
-							if dockerImg, hit := application.Entity["docker_image"]; !hit || dockerImg == nil {
-								oldAppGUID := application.Metadata["guid"].(string)
-								appZipPath := filepath.Join(backupDir, backupAppBitsDir, oldAppGUID+".zip")
-								err = appBits.UploadDroplet(appGUID, appZipPath)
-								if err != nil {
-									showWarning(fmt.Sprintf("Could not upload app bits for app %s: %s", application.Entity["name"].(string), err.Error()))
-								}
-							}
-
-							state := application.Entity["state"].(string)
-							a.State = state
-							updateApp(appGUID, a)
-
-							boundRoute := false
-							if application.Entity["routes"] != nil {
-								routes := application.Entity["routes"].(*[]*models.ResourceModel)
-								for _, rt := range *routes {
-									domain := rt.Entity["domain"].(*models.ResourceModel)
-
-									r := route{
-										SpaceGUID: spaceGUID,
-										Port:      rt.Entity["port"],
-										Path:      rt.Entity["path"],
-										Host:      rt.Entity["host"],
-									}
-
-									domainName := domain.Entity["name"].(string)
-
-									if domain.Entity["owning_organization_guid"] == nil {
-										domainGUID := getSharedDomainGUID(domainName)
-										if domainGUID == "" {
-											showWarning(fmt.Sprintf("Could not find shared domain %s", domainName))
-											continue
-										}
-										r.DomainGUID = domainGUID
-									} else {
-										domainGUID := getPrivateDomainGUID(domainName)
-										if domainGUID == "" {
-											showWarning(fmt.Sprintf("Could not find private domain %s", domainName))
-											continue
-										}
-										r.DomainGUID = domainGUID
-									}
-									routeGUID := createRoute(r)
-									showInfo(fmt.Sprintf("Binding route %s.%s to app %s", r.Host, domainName, a.Name))
-									err = bindRoute(appGUID, routeGUID)
-									if err != nil {
-										showWarning(fmt.Sprintf("Error binding route %s.%s to app %s: %s", r.Host, domainName, a.Name, err.Error()))
-									} else {
-										boundRoute = true
-										showInfo(fmt.Sprintf("Successfully bound route %s.%s to app %s", r.Host, domainName, a.Name))
-									}
-								}
-							}
-
-							if !boundRoute {
-								domain := getFirstSharedDomainGUID()
-								if domain == nil {
-									showWarning(fmt.Sprintf("Could not find any shared domain for app %s.", a.Name))
-								} else {
-									r := route{
-										SpaceGUID:  spaceGUID,
-										Host:       appGUID,
-										DomainGUID: domain.Metadata["guid"].(string),
-									}
-									routeGUID := createRoute(r)
-									showInfo(fmt.Sprintf("Binding new route to app %s", a.Name))
-									err = bindRoute(appGUID, routeGUID)
-									if err != nil {
-										showWarning(fmt.Sprintf("Error binding new route to app %s: %s", a.Name, err.Error()))
-									} else {
-										showInfo(fmt.Sprintf("Successfully bound new route to app %s", a.Name))
-									}
-
-								}
-							}
-							appIndex++
-						}
+		if !boundRoute {
+			routeEntry := restoreEntry{Type: "route", Key: appGUID}
+			domain, err := getFirstSharedDomainGUID()
+			if err == nil && domain == nil {
+				err = fmt.Errorf("Could not find any shared domain")
+			}
+			if err == nil {
+				routeEntry.Key = appGUID + "." + fmt.Sprint(domain.Entity["name"])
+			}
+			if err != nil || !report.resumed(&routeEntry) {
+				if err == nil {
+					r := route{
+						SpaceGUID:  spaceGUID,
+						Host:       appGUID,
+						DomainGUID: domain.Metadata["guid"].(string),
 					}
+					routeEntry.NewGUID, routeEntry.Action, err = createRoute(r)
 				}
+				if !report.restored(routeEntry, err) {
+					continue
+				}
+			}
+			mappingEntry := restoreEntry{Type: "route_mapping", Key: routeEntry.Key + " " + appEntry.Key, Action: actionCreated}
+			if report.resumed(&mappingEntry) {
+				continue
+			}
+			showInfo(fmt.Sprintf("Binding new route to app %s", application.Name))
+			err = bindRoute(appGUID, routeEntry.NewGUID)
+			if report.restored(mappingEntry, err) {
+				showInfo(fmt.Sprintf("Successfully bound new route to app %s", application.Name))
 			}
 		}
 	}
 
-	if includeSecurityGroups {
-		ccResources := util.CreateSecurityGroupsCCResources(nil)
-		securityGroups := ccResources.TransformToResourceModels(backupObject.SecurityGroups)
-		for _, sg := range *securityGroups {
-			spaces, ok := sg.Entity["spaces"].(*[]*models.ResourceModel)
-			var newSpaces []string
-			if ok {
-				newSpaces = make([]string, len(*spaces))
-				for i, s := range *spaces {
-					newSpaces[i] = spaceGuids[(s.Metadata["guid"]).(string)]
-				}
-			}
+	return pending
+}
 
-			g := securityGroup{
-				Name:           sg.Entity["name"].(string),
-				Rules:          sg.Entity["rules"],
-				SpaceGuids:     newSpaces,
-				RunningDefault: sg.Entity["running_default"].(bool),
-				StagingDefault: sg.Entity["staging_default"].(bool),
-			}
+// pendingApp is a restored app waiting for its bits before its state is
+// applied
+type pendingApp struct {
+	entry restoreEntry
+	app   app
+	// zipPath holds the bits to upload, if any
+	zipPath string
+	// restage skips the droplet of the app even if it was saved
+	restage bool
+	// dropletRestored is set once the droplet of the app was restored
+	dropletRestored bool
+}
 
-			_, err = restoreSecurityGroup(g)
-			if err != nil {
-				showWarning(fmt.Sprintf("Error restoring security group %s: %s", g.Name, err.Error()))
-			}
+// restoreAppWithBindings creates the app of appEntry, on the stack its
+// stack is mapped to, with its service bindings. It returns nil when the
+// app could not be created.
+func restoreAppWithBindings(application models.App, spaceGUID string, appEntry restoreEntry,
+	serviceInstanceGuids map[string]string, stacks stackMap, report *failureReport) *pendingApp {
+	// When docker image, we have to pretend the app has no stack
+	stackGUID := ""
+	stack := stacks.target(application.Stack)
+	if !application.Docker() {
+		var err error
+		stackGUID, err = getStackGUID(stack)
+		if err == nil && stackGUID == "" {
+			err = fmt.Errorf("Stack %s not found", stack)
+		}
+		if err != nil {
+			report.restored(appEntry, err)
+			return nil
+		}
+	}
+
+	a := app{
+		Name:               application.Name,
+		SpaceGUID:          spaceGUID,
+		Diego:              application.Diego,
+		Ports:              application.Ports,
+		Memory:             application.Memory,
+		Instances:          application.Instances,
+		DiskQuota:          application.DiskQuota,
+		StackGUID:          stackGUID,
+		Command:            application.Command,
+		Buildpack:          application.Buildpack,
+		HealthCheckType:    application.HealthCheckType,
+		HealthCheckTimeout: application.HealthCheckTimeout,
+		EnableSSH:          application.EnableSSH,
+		DockerImage:        application.DockerImage,
+		EnvironmentJSON:    application.EnvironmentJSON,
+	}
+
+	appGUID, err := restoreApp(a)
+	if ccErr, taken := err.(*ccError); taken && ccErr.Code == "CF-AppNameTaken" && report.resuming() {
+		// The previous run was interrupted after creating the app
+		if guid := getGUIDByQuery("apps", "name:"+a.Name, "space_guid:"+spaceGUID); guid != "" {
+			appGUID, err = guid, nil
+			appEntry.Action = actionUpdated
 		}
 	}
+	if err != nil {
+		report.restored(appEntry, err)
+		return nil
+	}
+	appEntry.NewGUID = appGUID
+
+	// A droplet only runs on the stack it was staged for
+	restored := &pendingApp{entry: appEntry, app: a, restage: stack != application.Stack}
+	if !application.Docker() {
+		bitsEntry, dropletEntry := appEntry, appEntry
+		bitsEntry.Type, bitsEntry.Action = "app_bits", actionUpdated
+		dropletEntry.Type, dropletEntry.Action = "app_droplet", actionUpdated
+		if !report.resumed(&dropletEntry) && !report.resumed(&bitsEntry) {
+			restored.zipPath = filepath.Join(backupAppBitsDir, application.GUID+".zip")
+		}
+	}
+
+	for _, oldInstanceGUID := range application.ServiceBindings {
+		bindingEntry := restoreEntry{Type: "service_binding", Key: appEntry.Key + " " + oldInstanceGUID}
+		if report.resumed(&bindingEntry) {
+			continue
+		}
+		instanceGUID := serviceInstanceGuids[oldInstanceGUID]
+		if instanceGUID == "" {
+			report.skipped(bindingEntry, "Service instance was not restored")
+			continue
+		}
+		showInfo(fmt.Sprintf("Binding service instance %s to app %s", instanceGUID, a.Name))
+		var err error
+		bindingEntry.NewGUID, bindingEntry.Action, err = bindService(serviceBinding{AppGUID: appGUID, ServiceInstanceGUID: instanceGUID}, a.Name)
+		if report.restored(bindingEntry, err) {
+			showInfo(fmt.Sprintf("Successfully bound service instance %s to app %s", instanceGUID, a.Name))
+		}
+	}
+
+	restored.app.State = application.State
+	return restored
 }
 
-func restoreSecurityGroup(securityGroup securityGroup) (string, error) {
+// startApps uploads the bits of the restored apps on transferWorkers
+// concurrent workers, then applies the state of each app. Apps with a
+// droplet in droplets also get it back, so that they are not staged
+// again. It returns the apps that were started.
+func startApps(apps []*pendingApp, droplets map[string]models.Droplet, report *failureReport) []*pendingApp {
+	var uploads []*pendingApp
+	for _, pending := range apps {
+		if pending.zipPath != "" {
+			uploads = append(uploads, pending)
+		}
+	}
+
+	_, planning := restoreCC.(*dryRunConnection)
+	progress := newTransferProgress(len(uploads), !planning && len(uploads) > 0)
+	workers := transferWorkers
+	packager := util.Packager(newTransferPackager(progress))
+	if planning {
+		// The plan is not safe for concurrent use
+		workers, packager = 1, newRestorePackager()
+	}
+
+	errs := runTransfers(len(uploads), workers, progress, func(i int) error {
+		upload := uploads[i]
+		// The bits are the source package of the app even when its droplet
+		// is restored, so that the app can be restaged and backed up later
+		if err := packager.UploadDroplet(upload.entry.NewGUID, upload.zipPath); err != nil {
+			return err
+		}
+		droplet, staged := droplets[upload.entry.OldGUID]
+		if !staged || upload.restage {
+			return nil
+		}
+		dropletPath := filepath.Join(backupDropletsDir, upload.entry.OldGUID+".tgz")
+		if _, err := os.Stat(dropletPath); err != nil {
+			return nil
+		}
+		// The droplet is created after the package, so that the CC does not
+		// take the package for a newer one still to be staged
+		if err := restoreDroplet(packager, upload.entry.NewGUID, dropletPath, droplet); err != nil {
+			showWarning(fmt.Sprintf("Could not restore the droplet of app %s, staging its bits instead: %s", upload.entry.Key, err.Error()))
+			return nil
+		}
+		upload.dropletRestored = true
+		return nil
+	})
+	if len(uploads) > 0 && !planning {
+		progress.finish("Uploaded bits")
+	}
+	for i, upload := range uploads {
+		bitsEntry := upload.entry
+		bitsEntry.Type, bitsEntry.Action = "app_bits", actionUpdated
+		if upload.dropletRestored {
+			bitsEntry.Type = "app_droplet"
+		}
+		report.restored(bitsEntry, errs[i])
+	}
+
+	var started []*pendingApp
+	for _, pending := range apps {
+		if report.restored(pending.entry, updateApp(pending.entry.NewGUID, pending.app)) && pending.app.State == "STARTED" {
+			started = append(started, pending)
+		}
+	}
+	return started
+}
+
+func boundToSpaces(spaces []models.Space, spaceFilter map[string]bool) bool {
+	for _, s := range spaces {
+		if spaceFilter[s.GUID] {
+			return true
+		}
+	}
+	return false
+}
+
+// getLiveSpaceGUID returns the GUID of the space with the same org and space
+// name on the target; if not found, an empty string is returned.
+func getLiveSpaceGUID(s models.Space) string {
+	if s.OrganizationName == "" || s.Name == "" {
+		return ""
+	}
+	orgGUID := getGUIDByQuery("organizations", "name:"+s.OrganizationName)
+	if orgGUID == "" {
+		return ""
+	}
+	return getGUIDByQuery("spaces", "name:"+s.Name, "organization_guid:"+orgGUID)
+}
+
+func restoreSecurityGroup(securityGroup securityGroup) (string, string, error) {
 	showInfo(fmt.Sprintf("Restoring security group %s", securityGroup.Name))
-	resources := util.GetResources(CliConnection, "/v2/security_groups?q=name:"+securityGroup.Name, 1)
+	resources, err := util.GetResources(restoreCC, "/v2/security_groups?q=name:"+securityGroup.Name, 1)
+	if err != nil {
+		return "", "", err
+	}
+	action := actionCreated
 	for _, u := range resources {
 		if u.Entity["name"].(string) == securityGroup.Name {
 			showInfo(fmt.Sprintf("Deleting old security group %s", securityGroup.Name))
 			err := deleteSecurityGroup(u.Metadata["guid"].(string))
 			if err != nil {
-				return "", err
+				return "", "", err
 			}
+			action = actionRecreated
 			break
 		}
 	}
-	return createSecurityGroup(securityGroup)
+	result, err := createSecurityGroup(securityGroup)
+	return result, action, err
 }
 
 func deleteSecurityGroup(guid string) error {
-	_, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/security_groups/"+guid, "-H", "Content-Type: application/x-www-form-urlencoded",
-		"-X", "DELETE")
+	_, err := invokeCC("DELETE", "/v2/security_groups/"+guid, nil)
 	if err != nil {
 		return err
 	}
@@ -834,9 +1676,7 @@ func deleteSecurityGroup(guid string) error {
 }
 
 func deleteQuota(guid string) error {
-	_, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/quota_definitions/"+guid, "-H", "Content-Type: application/x-www-form-urlencoded",
-		"-X", "DELETE")
+	_, err := invokeCC("DELETE", "/v2/quota_definitions/"+guid, nil)
 	if err != nil {
 		return err
 	}
@@ -845,9 +1685,7 @@ func deleteQuota(guid string) error {
 }
 
 func deleteSpaceQuota(guid string) error {
-	_, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/space_quota_definitions/"+guid, "-H", "Content-Type: application/x-www-form-urlencoded",
-		"-X", "DELETE")
+	_, err := invokeCC("DELETE", "/v2/space_quota_definitions/"+guid, nil)
 	if err != nil {
 		return err
 	}
@@ -858,41 +1696,33 @@ func deleteSpaceQuota(guid string) error {
 func createSecurityGroup(securityGroup securityGroup) (string, error) {
 	showInfo(fmt.Sprintf("Creating security group: %s", securityGroup.Name))
 	oJSON, err := json.Marshal(securityGroup)
-	util.FreakOut(err)
+	if err != nil {
+		return "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/security_groups", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/security_groups", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create security group %s, exception message: %s",
-			securityGroup.Name, err.Error()))
+		return "", err
 	}
 	result, _, err := getResult(resp, "name", securityGroup.Name)
 	if err != nil {
-		showWarning(fmt.Sprintf("Error restoring security group %s: %s", securityGroup.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully restored security group %s", securityGroup.Name))
+		return "", err
 	}
+	showInfo(fmt.Sprintf("Successfully restored security group %s", securityGroup.Name))
 
 	if securityGroup.RunningDefault {
 		showInfo(fmt.Sprintf("Restoring running default security group %s", securityGroup.Name))
-		resp, err = CliConnection.CliCommandWithoutTerminalOutput("curl",
-			fmt.Sprintf("/v2/config/running_security_groups/%s", result),
-			"-d", "", "-X", "PUT")
+		_, err = invokeCC("PUT", fmt.Sprintf("/v2/config/running_security_groups/%s", result), nil)
 		if err != nil {
-			showWarning(fmt.Sprintf("Could not restore running security group %s, exception message: %s",
-				securityGroup.Name, err))
+			return result, fmt.Errorf("Could not make it a running default: %s", err.Error())
 		}
 	}
 
 	if securityGroup.StagingDefault {
 		showInfo(fmt.Sprintf("Restoring staging default security group %s", securityGroup.Name))
-		resp, err = CliConnection.CliCommandWithoutTerminalOutput("curl",
-			fmt.Sprintf("/v2/config/staging_security_groups/%s", result),
-			"-d", "", "-X", "PUT")
+		_, err = invokeCC("PUT", fmt.Sprintf("/v2/config/staging_security_groups/%s", result), nil)
 		if err != nil {
-			showWarning(fmt.Sprintf("Could not restore staging security group %s, exception message: %s",
-				securityGroup.Name, err))
+			return result, fmt.Errorf("Could not make it a staging default: %s", err.Error())
 		}
 	}
 
@@ -900,9 +1730,7 @@ func createSecurityGroup(securityGroup securityGroup) (string, error) {
 }
 
 func bindRoute(appGUID, routeGUID string) error {
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/apps/"+appGUID+"/routes/"+routeGUID, "-H", "Content-Type: application/x-www-form-urlencoded",
-		"-X", "PUT")
+	resp, err := invokeCC("PUT", "/v2/apps/"+appGUID+"/routes/"+routeGUID, nil)
 	if err != nil {
 		return err
 	}
@@ -913,101 +1741,106 @@ func bindRoute(appGUID, routeGUID string) error {
 	return nil
 }
 
-func createRoute(route route) string {
+func createRoute(route route) (string, string, error) {
 	showInfo(fmt.Sprintf("Creating route: %s", route.Host))
 	oJSON, err := json.Marshal(route)
-	util.FreakOut(err)
+	if err != nil {
+		return "", "", err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/routes", "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "POST")
+	resp, err := invokeCC("POST", "/v2/routes", oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not create route %s, exception message: %s",
-			route.Host, err.Error()))
+		return "", "", err
 	}
-	result, obj, err := getResult(resp, "host", route.Host.(string))
+	result, obj, err := getResult(resp, "host", route.Host)
+	action := actionCreated
 	if err != nil && obj != nil && obj["error_code"] == "CF-RouteHostTaken" {
 		// The route already exists; try to patch the existing one
-		guid := getGUIDByQuery("routes", "host:"+route.Host.(string))
+		guid := getGUIDByQuery("routes", "host:"+route.Host)
 		if guid != "" {
-			resp, err = CliConnection.CliCommandWithoutTerminalOutput("curl",
-				"/v2/routes/"+guid, "-H", "Content-Type: application/json",
-				"-d", string(oJSON), "-X", "PUT")
+			resp, err = invokeCC("PUT", "/v2/routes/"+guid, oJSON)
 			if err != nil {
-				showWarning(fmt.Sprintf("Could not update route %s, exception message: %s",
-					route.Host, err.Error()))
+				return "", "", err
 			}
-			result, _, err = getResult(resp, "host", route.Host.(string))
+			result, _, err = getResult(resp, "host", route.Host)
+			action = actionUpdated
 		}
 	}
 	if err != nil {
-		showWarning(fmt.Sprintf("Error creating route %s: %s", route.Host, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully created route %s", route.Host))
+		return "", "", err
 	}
-	return result
+	showInfo(fmt.Sprintf("Successfully created route %s", route.Host))
+	return result, action, nil
 }
 
-func getFirstSharedDomainGUID() *models.ResourceModel {
-	resources := util.GetResources(CliConnection, "/v2/shared_domains", 1)
-	if len(resources) > 0 {
-		return resources[0]
+func getFirstSharedDomainGUID() (*models.ResourceModel, error) {
+	resources, err := util.GetResources(restoreCC, "/v2/shared_domains", 1)
+	if err != nil || len(resources) == 0 {
+		return nil, err
 	}
 
-	return nil
+	return resources[0], nil
 }
 
-func getSharedDomainGUID(domainName string) string {
-	resources := util.GetResources(CliConnection, "/v2/shared_domains?q=name:"+domainName, 1)
+func getSharedDomainGUID(domainName string) (string, error) {
+	resources, err := util.GetResources(restoreCC, "/v2/shared_domains?q=name:"+domainName, 1)
+	if err != nil {
+		return "", err
+	}
 	for _, u := range resources {
 		if u.Entity["name"].(string) == domainName {
-			return u.Metadata["guid"].(string)
+			return u.Metadata["guid"].(string), nil
 		}
 	}
 
-	return ""
+	return "", nil
 }
 
-func getPrivateDomainGUID(domainName string) string {
-	resources := util.GetResources(CliConnection, "/v2/private_domains?q=name:"+domainName, 1)
+func getPrivateDomainGUID(domainName string) (string, error) {
+	resources, err := util.GetResources(restoreCC, "/v2/private_domains?q=name:"+domainName, 1)
+	if err != nil {
+		return "", err
+	}
 	for _, u := range resources {
 		if u.Entity["name"].(string) == domainName {
-			return u.Metadata["guid"].(string)
+			return u.Metadata["guid"].(string), nil
 		}
 	}
 
-	return ""
+	return "", nil
 }
 
-func getStackGUID(stackName string) string {
-	resources := util.GetResources(CliConnection, "/v2/stacks?q=name:"+stackName, 1)
+func getStackGUID(stackName string) (string, error) {
+	resources, err := util.GetResources(restoreCC, "/v2/stacks?q=name:"+stackName, 1)
+	if err != nil {
+		return "", err
+	}
 	for _, u := range resources {
 		if u.Entity["name"].(string) == stackName {
-			return u.Metadata["guid"].(string)
+			return u.Metadata["guid"].(string), nil
 		}
 	}
 
-	return ""
+	return "", nil
 }
 
-func updateApp(guid string, app app) {
+func updateApp(guid string, app app) error {
 	showInfo(fmt.Sprintf("Updating app %s", app.Name))
 	oJSON, err := json.Marshal(app)
-	util.FreakOut(err)
+	if err != nil {
+		return err
+	}
 
-	resp, err := CliConnection.CliCommandWithoutTerminalOutput("curl",
-		"/v2/apps/"+guid, "-H", "Content-Type: application/json",
-		"-d", string(oJSON), "-X", "PUT")
+	resp, err := invokeCC("PUT", "/v2/apps/"+guid, oJSON)
 	if err != nil {
-		showWarning(fmt.Sprintf("Could not update app %s, exception message: %s",
-			app.Name, err.Error()))
+		return err
 	}
 	_, _, err = getResult(resp, "name", app.Name)
 	if err != nil {
-		showWarning(fmt.Sprintf("Error updating application %s: %s", app.Name, err.Error()))
-	} else {
-		showInfo(fmt.Sprintf("Successfully updated application %s", app.Name))
+		return err
 	}
+	showInfo(fmt.Sprintf("Successfully updated application %s", app.Name))
+	return nil
 }
 
 // restoreCmd represents the restore command
@@ -1019,13 +1852,93 @@ var restoreCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		includeSecurityGroups, _ := cmd.Flags().GetBool("include-security-groups")
 		includeQuotaDefinitions, _ := cmd.Flags().GetBool("include-quota-definitions")
-		restoreFromJSON(includeSecurityGroups, includeQuotaDefinitions)
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		orgs, _ := cmd.Flags().GetStringArray("org")
+		spaces, _ := cmd.Flags().GetStringArray("space")
+		apps, _ := cmd.Flags().GetStringArray("app")
+		archive, _ := cmd.Flags().GetString("archive")
+		resume, _ := cmd.Flags().GetBool("resume")
+		restage, _ := cmd.Flags().GetBool("restage")
+		stackPairs, _ := cmd.Flags().GetStringArray("stack-map")
+		stackMapFile, _ := cmd.Flags().GetString("stack-map-file")
+		domainPairs, _ := cmd.Flags().GetStringArray("domain-map")
+		domainMapFile, _ := cmd.Flags().GetString("domain-map-file")
+		wait, _ := cmd.Flags().GetBool("wait")
+		waitTimeout, _ := cmd.Flags().GetDuration("wait-timeout")
+
+		reportFile, _ := cmd.Flags().GetString("report")
+		report := &failureReport{}
+		startedAt := time.Now().UTC()
+
+		selector, err := newRestoreSelector(orgs, spaces, apps)
+		if err != nil {
+			exitWithReport(report, err)
+		}
+		stacks, err := parseMappings("stack-map", stackPairs, stackMapFile)
+		if err != nil {
+			exitWithReport(report, err)
+		}
+		domains, err := parseMappings("domain-map", domainPairs, domainMapFile)
+		if err != nil {
+			exitWithReport(report, err)
+		}
+
+		journalFile := backupFile + restoreJournalSuffix
+		if archive != "" {
+			journalFile = archive + restoreJournalSuffix
+		}
+		report.journal, err = openRestoreJournal(journalFile, resume, dryRun)
+		if err != nil {
+			exitWithReport(report, err)
+		}
+
+		cleanup := func() {}
+		if archive != "" {
+			cleanup = openArchive(archive)
+		}
+
+		err = restoreFromJSON(restoreOptions{
+			includeSecurityGroups:   includeSecurityGroups,
+			includeQuotaDefinitions: includeQuotaDefinitions,
+			dryRun:                  dryRun,
+			selector:                selector,
+			restage:                 restage,
+			stacks:                  stacks,
+			domains:                 domains,
+			wait:                    wait,
+			waitTimeout:             waitTimeout,
+		}, report)
+		cleanup()
+		if closeErr := report.journal.close(err == nil && len(report.failures) == 0); closeErr != nil {
+			showWarning(fmt.Sprintf("Could not close the restore journal %s: %s", journalFile, closeErr.Error()))
+		}
+		if reportFile != "" {
+			if writeErr := writeRestoreReport(reportFile, report, startedAt, err); writeErr != nil {
+				showWarning(fmt.Sprintf("Could not write the restore report %s: %s", reportFile, writeErr.Error()))
+			}
+		}
+		exitWithReport(report, err)
 	},
 }
 
 func init() {
 	restoreCmd.Flags().Bool("include-security-groups", false, "Restore security groups")
 	restoreCmd.Flags().Bool("include-quota-definitions", false, "Restore quota definitions")
+	restoreCmd.Flags().Bool("dry-run", false, "Print the restore plan without changing anything")
+	restoreCmd.Flags().StringArray("org", nil, "Restore only the orgs matching this glob, with their roles and private domains (repeatable)")
+	restoreCmd.Flags().StringArray("space", nil, "Restore only the spaces matching this org/space glob (repeatable)")
+	restoreCmd.Flags().StringArray("app", nil, "Restore only the apps matching this org/space/app glob (repeatable)")
+	restoreCmd.Flags().String("archive", "", "Restore from a snapshot archive instead of the current directory")
+	restoreCmd.Flags().String("report", "", "Write the outcome of every restored resource to this JSON file")
+	restoreCmd.Flags().IntVar(&transferWorkers, "transfers", 4, "Number of app bits uploaded concurrently")
+	restoreCmd.Flags().StringArray("stack-map", nil, "Restore the apps and buildpacks of stack OLD on stack NEW, as OLD=NEW (repeatable)")
+	restoreCmd.Flags().String("stack-map-file", "", "Read OLD=NEW stack mappings from this file, one per line")
+	restoreCmd.Flags().StringArray("domain-map", nil, "Restore domain OLD and the domains and routes below it under NEW, as OLD=NEW (repeatable)")
+	restoreCmd.Flags().String("domain-map-file", "", "Read OLD=NEW domain mappings from this file, one per line")
+	restoreCmd.Flags().Bool("restage", false, "Upload the app bits and stage them again instead of restoring the saved droplets")
+	restoreCmd.Flags().Bool("wait", false, "Wait for the restored apps to stage and run, and report the ones that did not")
+	restoreCmd.Flags().Duration("wait-timeout", 10*time.Minute, "How long --wait waits for the apps to run")
+	restoreCmd.Flags().Bool("resume", false, "Continue an interrupted restore after the steps recorded in its journal")
 	RootCmd.AddCommand(restoreCmd)
 
 	// Here you will define your flags and configuration settings.
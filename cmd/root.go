@@ -3,11 +3,15 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"code.cloudfoundry.org/cli/plugin"
+
+	"github.com/SUSE/cf-plugin-backup/util"
 )
 
 var (
@@ -17,15 +21,34 @@ var (
 	target     string
 	key        string
 
-	backupDir        string
-	backupAppBitsDir string
-	backupFile       string
+	backupDir           string
+	backupAppBitsDir    string
+	backupBuildpacksDir string
+	backupDropletsDir   string
+	backupFile          string
 
 	//CliConnection represents the cf cli connection
 	CliConnection     plugin.CliConnection
 	backupDestination string
 )
 
+// Default layout of a snapshot directory
+const (
+	defaultBackupFile    = "cf-backup.json"
+	defaultAppBitsDir    = "app-bits"
+	defaultBuildpacksDir = "buildpacks"
+	defaultDropletsDir   = "droplets"
+)
+
+// Config keys of the backup location, also read from CF_BACKUP_* variables
+const (
+	backupConfigEnvPrefix  = "CF_BACKUP"
+	backupConfigDirKey     = "dir"
+	backupConfigFileKey    = "file"
+	backupConfigBitsDirKey = "bits-dir"
+	retriesConfigKey       = "retries"
+)
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "cf-plugin-backup",
@@ -50,6 +73,32 @@ func init() {
 	// will be global for your application.
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cf-plugin-backup.yaml)")
+
+	RootCmd.PersistentFlags().String(backupConfigDirKey, "./", "directory holding the snapshot (env CF_BACKUP_DIR)")
+	RootCmd.PersistentFlags().String(backupConfigFileKey, defaultBackupFile, "snapshot file, relative to --dir (env CF_BACKUP_FILE)")
+	RootCmd.PersistentFlags().String(backupConfigBitsDirKey, defaultAppBitsDir, "app bits directory, relative to --dir (env CF_BACKUP_BITS_DIR)")
+	RootCmd.PersistentFlags().Int(retriesConfigKey, util.DefaultRetryPolicy.Attempts-1, "times a request failing with a 5xx, 429 or connection error is retried (env CF_BACKUP_RETRIES)")
+	for _, key := range []string{backupConfigDirKey, backupConfigFileKey, backupConfigBitsDirKey, retriesConfigKey} {
+		viper.BindPFlag(key, RootCmd.PersistentFlags().Lookup(key))
+	}
+}
+
+// inBackupDir resolves a path relative to the backup directory
+func inBackupDir(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(backupDir, path)
+}
+
+// setBackupDir points all backup paths to dir, keeping the file and bits
+// directory names
+func setBackupDir(dir, file, bitsDir string) {
+	backupDir = dir
+	backupFile = inBackupDir(file)
+	backupAppBitsDir = inBackupDir(bitsDir)
+	backupBuildpacksDir = inBackupDir(defaultBuildpacksDir)
+	backupDropletsDir = inBackupDir(defaultDropletsDir)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -60,14 +109,18 @@ func initConfig() {
 
 	viper.SetConfigName(".cf-plugin-backup") // name of config file (without extension)
 	viper.AddConfigPath("$HOME")             // adding home directory as first search path
-	viper.AutomaticEnv()                     // read in environment variables that match
+	viper.SetEnvPrefix(backupConfigEnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 
-	backupDir = "./"
-	backupAppBitsDir = "app-bits"
-	backupFile = "cf-backup.json"
+	setBackupDir(viper.GetString(backupConfigDirKey), viper.GetString(backupConfigFileKey), viper.GetString(backupConfigBitsDirKey))
+
+	retry := util.DefaultRetryPolicy
+	retry.Attempts = viper.GetInt(retriesConfigKey) + 1
+	util.SetRetryPolicy(retry)
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetBackupDir(t *testing.T) {
+	setBackupDir("/backups/2017-01-01", defaultBackupFile, defaultAppBitsDir)
+
+	if backupFile != filepath.Join("/backups/2017-01-01", "cf-backup.json") {
+		t.Fatalf("unexpected backup file %s", backupFile)
+	}
+	if backupAppBitsDir != filepath.Join("/backups/2017-01-01", "app-bits") {
+		t.Fatalf("unexpected app bits directory %s", backupAppBitsDir)
+	}
+	if backupBuildpacksDir != filepath.Join("/backups/2017-01-01", "buildpacks") {
+		t.Fatalf("unexpected buildpacks directory %s", backupBuildpacksDir)
+	}
+	if backupDropletsDir != filepath.Join("/backups/2017-01-01", "droplets") {
+		t.Fatalf("unexpected droplets directory %s", backupDropletsDir)
+	}
+
+	setBackupDir("/backups/2017-01-01", "/tmp/other.json", "/bits")
+
+	if backupFile != "/tmp/other.json" || backupAppBitsDir != "/bits" {
+		t.Fatalf("absolute paths must not be moved below the backup directory, got %s and %s", backupFile, backupAppBitsDir)
+	}
+}
+
+func TestInitConfig_ReadsEnvironment(t *testing.T) {
+	os.Setenv("CF_BACKUP_DIR", "/backups/env")
+	os.Setenv("CF_BACKUP_BITS_DIR", "bits")
+	defer os.Unsetenv("CF_BACKUP_DIR")
+	defer os.Unsetenv("CF_BACKUP_BITS_DIR")
+
+	initConfig()
+
+	if backupDir != "/backups/env" {
+		t.Fatalf("unexpected backup directory %s", backupDir)
+	}
+	if backupFile != filepath.Join("/backups/env", "cf-backup.json") {
+		t.Fatalf("unexpected backup file %s", backupFile)
+	}
+	if backupAppBitsDir != filepath.Join("/backups/env", "bits") {
+		t.Fatalf("unexpected app bits directory %s", backupAppBitsDir)
+	}
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+// restoreSelector limits a restore to the orgs, spaces and apps matching
+// the --org, --space (org/space) and --app (org/space/app) glob patterns.
+// A selector without patterns selects everything.
+type restoreSelector struct {
+	orgs   []string
+	spaces [][]string
+	apps   [][]string
+}
+
+func newRestoreSelector(orgs, spaces, apps []string) (*restoreSelector, error) {
+	selector := &restoreSelector{orgs: orgs}
+
+	for _, pattern := range orgs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --org pattern %q: %s", pattern, err)
+		}
+	}
+	for _, pattern := range spaces {
+		segments, err := splitSelector(pattern, 2)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --space pattern %q, expected org/space: %s", pattern, err)
+		}
+		selector.spaces = append(selector.spaces, segments)
+	}
+	for _, pattern := range apps {
+		segments, err := splitSelector(pattern, 3)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --app pattern %q, expected org/space/app: %s", pattern, err)
+		}
+		selector.apps = append(selector.apps, segments)
+	}
+
+	return selector, nil
+}
+
+func splitSelector(pattern string, length int) ([]string, error) {
+	segments := strings.Split(pattern, "/")
+	if len(segments) != length {
+		return nil, fmt.Errorf("wrong number of segments")
+	}
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+func matchSegments(patterns []string, names ...string) bool {
+	for i, name := range names {
+		if matched, _ := path.Match(patterns[i], name); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// all is true when no pattern was given and the whole backup is restored
+func (selector *restoreSelector) all() bool {
+	return selector == nil || len(selector.orgs)+len(selector.spaces)+len(selector.apps) == 0
+}
+
+// orgSelected is true when the whole org is restored
+func (selector *restoreSelector) orgSelected(org string) bool {
+	if selector.all() {
+		return true
+	}
+	for _, pattern := range selector.orgs {
+		if matched, _ := path.Match(pattern, org); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// spaceSelected is true when the whole space is restored
+func (selector *restoreSelector) spaceSelected(org, space string) bool {
+	if selector.orgSelected(org) {
+		return true
+	}
+	for _, pattern := range selector.spaces {
+		if matchSegments(pattern, org, space) {
+			return true
+		}
+	}
+	return false
+}
+
+// appSelected is true when the app is restored
+func (selector *restoreSelector) appSelected(org, space, app string) bool {
+	if selector.spaceSelected(org, space) {
+		return true
+	}
+	for _, pattern := range selector.apps {
+		if matchSegments(pattern, org, space, app) {
+			return true
+		}
+	}
+	return false
+}
+
+// orgTouched is true when the org or anything below it is restored
+func (selector *restoreSelector) orgTouched(org string) bool {
+	if selector.orgSelected(org) {
+		return true
+	}
+	for _, pattern := range selector.spaces {
+		if matchSegments(pattern, org) {
+			return true
+		}
+	}
+	for _, pattern := range selector.apps {
+		if matchSegments(pattern, org) {
+			return true
+		}
+	}
+	return false
+}
+
+// spaceTouched is true when the space or any of its apps is restored
+func (selector *restoreSelector) spaceTouched(org, space string) bool {
+	if selector.spaceSelected(org, space) {
+		return true
+	}
+	for _, pattern := range selector.apps {
+		if matchSegments(pattern, org, space) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreDependencies holds what a selective restore needs besides the
+// selected orgs, spaces and apps; keys are old guids, or names for shared
+// domains and buildpacks
+type restoreDependencies struct {
+	quotas           map[string]bool
+	spaceQuotas      map[string]bool
+	spaces           map[string]bool
+	sharedDomains    map[string]bool
+	privateDomains   map[string]bool
+	buildpacks       map[string]bool
+	serviceInstances map[string]bool
+}
+
+// dependencies collects the dependencies of the selected resources; it
+// returns nil when everything is restored
+func (selector *restoreSelector) dependencies(orgs []models.Organization) *restoreDependencies {
+	if selector.all() {
+		return nil
+	}
+
+	deps := &restoreDependencies{
+		quotas:           make(map[string]bool),
+		spaceQuotas:      make(map[string]bool),
+		spaces:           make(map[string]bool),
+		sharedDomains:    make(map[string]bool),
+		privateDomains:   make(map[string]bool),
+		buildpacks:       make(map[string]bool),
+		serviceInstances: make(map[string]bool),
+	}
+
+	for _, organization := range orgs {
+		if !selector.orgTouched(organization.Name) {
+			continue
+		}
+		if organization.QuotaGUID != "" {
+			deps.quotas[organization.QuotaGUID] = true
+		}
+
+		for _, sp := range organization.Spaces {
+			if !selector.spaceTouched(organization.Name, sp.Name) {
+				continue
+			}
+			deps.spaces[sp.GUID] = true
+			if sp.SpaceQuotaGUID != "" {
+				deps.spaceQuotas[sp.SpaceQuotaGUID] = true
+			}
+
+			for _, application := range sp.Apps {
+				if !selector.appSelected(organization.Name, sp.Name, application.Name) {
+					continue
+				}
+				if application.Buildpack != nil {
+					deps.buildpacks[*application.Buildpack] = true
+				}
+				for _, rt := range application.Routes {
+					if rt.Domain.Shared() {
+						deps.sharedDomains[rt.Domain.Name] = true
+					} else {
+						deps.privateDomains[rt.Domain.GUID] = true
+					}
+				}
+				for _, instanceGUID := range application.ServiceBindings {
+					deps.serviceInstances[instanceGUID] = true
+				}
+			}
+		}
+	}
+
+	return deps
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func TestRestoreSelector_EmptySelectsEverything(t *testing.T) {
+	selector, err := newRestoreSelector(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !selector.appSelected("o", "s", "a") {
+		t.Fatal("app should be selected")
+	}
+}
+
+func TestRestoreSelector_SpaceGlob(t *testing.T) {
+	selector, err := newRestoreSelector(nil, []string{"team-*/prod"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !selector.orgTouched("team-a") || selector.orgSelected("team-a") {
+		t.Fatal("org team-a should only be touched")
+	}
+	if !selector.appSelected("team-a", "prod", "web") {
+		t.Fatal("apps of team-a/prod should be selected")
+	}
+	if selector.spaceTouched("team-a", "dev") {
+		t.Fatal("team-a/dev should not be touched")
+	}
+	if selector.orgTouched("other") {
+		t.Fatal("org other should not be touched")
+	}
+}
+
+func TestRestoreSelector_AppGlob(t *testing.T) {
+	selector, err := newRestoreSelector(nil, nil, []string{"o/*/api-?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !selector.spaceTouched("o", "s") || selector.spaceSelected("o", "s") {
+		t.Fatal("space o/s should only be touched")
+	}
+	if !selector.appSelected("o", "s", "api-1") {
+		t.Fatal("api-1 should be selected")
+	}
+	if selector.appSelected("o", "s", "web") {
+		t.Fatal("web should not be selected")
+	}
+}
+
+func TestRestoreSelector_InvalidPatterns(t *testing.T) {
+	if _, err := newRestoreSelector(nil, []string{"only-org"}, nil); err == nil {
+		t.Fatal("space pattern without org should be rejected")
+	}
+	if _, err := newRestoreSelector([]string{"["}, nil, nil); err == nil {
+		t.Fatal("malformed glob should be rejected")
+	}
+}
+
+func TestRestoreSelector_DependenciesOnlyHoldUsedPrivateDomains(t *testing.T) {
+	selector, err := newRestoreSelector(nil, []string{"o/prod"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := models.Domain{GUID: "d1", Name: "apps.internal", OwningOrganizationGUID: "o"}
+	orgs := []models.Organization{{Name: "o",
+		PrivateDomains: []models.Domain{used, {GUID: "d2", Name: "other.internal", OwningOrganizationGUID: "o"}},
+		Spaces: []models.Space{{Name: "prod", Apps: []models.App{
+			{Name: "web", Routes: []models.Route{{Host: "web", Domain: used}}},
+		}}},
+	}}
+
+	deps := selector.dependencies(orgs)
+	if !deps.privateDomains["d1"] || deps.privateDomains["d2"] {
+		t.Fatalf("expected only the private domain of the routes, got %v", deps.privateDomains)
+	}
+	if selector.orgSelected("o") {
+		t.Fatal("the org roles should be left out")
+	}
+}
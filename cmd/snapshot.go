@@ -2,18 +2,23 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/SUSE/cf-plugin-backup/models"
 	"github.com/SUSE/cf-plugin-backup/util"
-	termuiprogressbar "github.com/SUSE/termui/termprogressbar"
 )
 
+// crawlWorkers is the number of CC relations retrieved concurrently by
+// the commands crawling the CC
+var crawlWorkers int
+
 // snapshotCmd represents the snapshot command
 var snapshotCmd = &cobra.Command{
 	Use:   "backup-snapshot",
@@ -21,103 +26,300 @@ var snapshotCmd = &cobra.Command{
 	Long: `Create a new CloudFoundry backup snapshot to a local file.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		var currentIndex int
-
-		orgQuotas, err := util.GetOrgQuotaDefinitions(&util.CliConnectionCCApi{CliConnection: CliConnection})
-		util.FreakOut(err)
-		log.Println("org quota definitions done")
-		spaceQuotas, err := util.GetSpaceQuotaDefinitions(&util.CliConnectionCCApi{CliConnection: CliConnection})
-		util.FreakOut(err)
-		log.Println("space quota definitions done")
-		backupResources, err := util.GetOrgsResourcesRecurively(&util.CliConnectionCCApi{CliConnection: CliConnection})
-		util.FreakOut(err)
-		log.Println("orgs done")
-		sharedDomains, err := util.GetSharedDomains(&util.CliConnectionCCApi{CliConnection: CliConnection})
-		util.FreakOut(err)
-		log.Println("shared domains done")
-		securityGroups, err := util.GetSecurityGroups(&util.CliConnectionCCApi{CliConnection: CliConnection})
-		util.FreakOut(err)
-		log.Println("groups done")
-		featureFlags, err := util.GetFeatureFlags(&util.CliConnectionCCApi{CliConnection: CliConnection})
-		util.FreakOut(err)
-		log.Println("feature flags done")
-
-		backupJSON, err := util.CreateBackupJSON(models.BackupModel{
-			OrgQuotas:      orgQuotas,
-			SpaceQuotas:    spaceQuotas,
-			Organizations:  backupResources,
-			SharedDomains:  sharedDomains,
-			SecurityGroups: securityGroups,
-			FeatureFlags:   featureFlags,
-		})
+		util.SetCrawlWorkers(crawlWorkers)
+		report := &failureReport{}
+
+		archive, _ := cmd.Flags().GetString("archive")
+		if archive == "" {
+			exitWithReport(report, takeSnapshot(report))
+			return
+		}
+
+		archiveDir, cleanup := useArchiveDir()
+		err := takeSnapshot(report)
+		if err == nil {
+			log.Printf("Writing %s", archive)
+			err = util.CreateArchive(archive, archiveDir)
+		}
+		cleanup()
+		exitWithReport(report, err)
+	},
+}
+
+// takeSnapshot writes the backup json and saves the app and buildpack bits,
+// recording the resources that could not be captured in report
+func takeSnapshot(report *failureReport) error {
+	startedAt := time.Now().UTC()
+	ccAPI, err := util.NewHTTPCCApi(CliConnection)
+	if err != nil {
+		return err
+	}
+
+	snapshot := collectBackupModel(ccAPI, report)
+	if err := ccAPI.AuthError(); err != nil {
+		return err
+	}
+	metadata, err := newBackupMetadata(ccAPI, snapshot, startedAt)
+	if err != nil {
+		return err
+	}
+	snapshot.Metadata = metadata
+
+	backupJSON, err := writeBackupFile(snapshot)
+	if err != nil {
+		return err
+	}
+
+	// Save app bits
+
+	packager := &util.CFPackager{
+		Cli:    CliConnection,
+		Writer: new(util.CFFileWriter),
+		Reader: new(util.CFFileReader),
+	}
 
-		util.FreakOut(err)
+	backupModel := models.BackupModel{}
+	err = json.Unmarshal([]byte(backupJSON), &backupModel)
+	if err != nil {
+		return err
+	}
 
-		err = ioutil.WriteFile(backupFile, []byte(backupJSON), 0644)
-		util.FreakOut(err)
+	err = os.MkdirAll(backupAppBitsDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = removePartialBits(backupAppBitsDir)
+	if err != nil {
+		return err
+	}
 
-		// Save app bits
+	// apps left out for a relation the crawler could not retrieve were
+	// recorded as crawl failures already; their bits are not saved
+	orgs, err := util.RestoreOrganizations(backupModel.Organizations)
+	if _, skipped := err.(*models.SkippedError); err != nil && !skipped {
+		return err
+	}
 
-		packager := &util.CFPackager{
-			Cli:    CliConnection,
-			Writer: new(util.CFFileWriter),
-			Reader: new(util.CFFileReader),
+	var appsToBackup []models.App
+	for _, org := range orgs {
+		for _, space := range org.Spaces {
+			for _, app := range space.Apps {
+				if !app.Docker() {
+					appsToBackup = append(appsToBackup, app)
+				}
+			}
 		}
-		appBits := util.NewCFDroplet(CliConnection, packager)
+	}
+
+	if len(appsToBackup) > 0 {
 
-		backupModel := models.BackupModel{}
-		err = json.Unmarshal([]byte(backupJSON), &backupModel)
-		util.FreakOut(err)
+		log.Printf("Saving bits for %d apps", len(appsToBackup))
 
-		err = os.Mkdir(filepath.Join(backupDir, backupAppBitsDir), 0755)
-		if err != nil && !os.IsExist(err) {
-			util.FreakOut(err)
+		var pending []models.App
+		for _, app := range appsToBackup {
+			appZipPath := filepath.Join(backupAppBitsDir, app.GUID+".zip")
+			if util.BitsUpToDate(appZipPath, snapshot.PackageHashes[app.GUID]) {
+				report.record("app bits", app.Name, nil)
+				continue
+			}
+			pending = append(pending, app)
+		}
+		if upToDate := len(appsToBackup) - len(pending); upToDate > 0 {
+			log.Printf("Bits of %d apps were already saved and unchanged", upToDate)
 		}
 
-		var appsToBackup []*models.ResourceModel
-
-		resources := util.RestoreOrgResourceModels(backupModel.Organizations)
-		if resources != nil {
-			for _, org := range *resources {
-				if org.Entity["spaces"] != nil {
-					for _, space := range *(org.Entity["spaces"].(*[]*models.ResourceModel)) {
-						if space.Entity["apps"] != nil {
-							for _, app := range *(space.Entity["apps"].(*[]*models.ResourceModel)) {
-								if dockerImg, hit := app.Entity["docker_image"]; !hit || dockerImg == nil {
-									appsToBackup = append(appsToBackup, app)
-								}
-							}
-						}
-					}
-				}
+		progress := newTransferProgress(len(pending), len(pending) > 0)
+		appBits := util.NewCFDroplet(CliConnection, newTransferPackager(progress))
+		errs := runTransfers(len(pending), transferWorkers, progress, func(i int) error {
+			return appBits.SaveDroplet(pending[i].GUID, filepath.Join(backupAppBitsDir, pending[i].GUID+".zip"))
+		})
+		if len(pending) > 0 {
+			progress.finish("Saved bits")
+		}
+
+		for i, app := range pending {
+			if errs[i] != nil {
+				log.Printf("Could not save bits for %v: %v", app.GUID, errs[i])
 			}
+			report.record("app bits", app.Name, errs[i])
 		}
+	}
 
-		if len(appsToBackup) > 0 {
+	err = saveDroplets(appsToBackup, snapshot.Droplets, report)
+	if err != nil {
+		return err
+	}
 
-			log.Printf("Saving bits for %d apps", len(appsToBackup))
+	// Save buildpack bits
 
-			termuiPGBar := termuiprogressbar.NewProgressBar(len(appsToBackup), true)
-			termuiPGBar.Start()
+	err = os.MkdirAll(backupBuildpacksDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = removePartialBits(backupBuildpacksDir)
+	if err != nil {
+		return err
+	}
 
-			for _, app := range appsToBackup {
-				appGUID := app.Metadata["guid"].(string)
-				if currentIndex < len(appsToBackup) {
-					termuiPGBar.Increment()
-					currentIndex++
-				}
-				appZipPath := filepath.Join(backupDir, backupAppBitsDir, appGUID+".zip")
-				err := appBits.SaveDroplet(appGUID, appZipPath)
-				if err != nil {
-					log.Printf("Could not save bits for %v: %v", appGUID, err)
-				}
+	if backupModel.Buildpacks != nil {
+		for _, buildpack := range *util.RestoreBuildpackResourceModels(backupModel.Buildpacks) {
+			if buildpack.Entity["filename"] == nil {
+				continue
+			}
+			buildpackGUID := buildpack.Metadata["guid"].(string)
+			buildpackZipPath := filepath.Join(backupBuildpacksDir, buildpackGUID+".zip")
+
+			log.Printf("Saving bits for buildpack %v", buildpack.Entity["name"])
+			data, err := packager.GetBuildpack(buildpackGUID)
+			if err == nil {
+				err = packager.SaveDropletToFile(buildpackZipPath, data)
+				data.Close()
+			}
+			if err != nil {
+				log.Printf("Could not save bits for buildpack %v: %v", buildpack.Entity["name"], err)
 			}
-			termuiPGBar.FinishPrint("App bits saved")
+			report.record("buildpack bits", fmt.Sprint(buildpack.Entity["name"]), err)
 		}
-	},
+	}
+
+	snapshot.Metadata.FinishedAt = time.Now().UTC()
+	_, err = writeBackupFile(snapshot)
+	return err
+}
+
+// removePartialBits removes the downloads an interrupted snapshot left in dir
+func removePartialBits(dir string) error {
+	removed, err := util.RemovePartialBits(dir)
+	for _, name := range removed {
+		log.Printf("Removed partial download %s", filepath.Join(dir, name))
+	}
+	return err
+}
+
+// writeBackupFile saves the backup json and returns it
+func writeBackupFile(backup models.BackupModel) (string, error) {
+	backupJSON, err := util.CreateBackupJSON(backup)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(backupFile), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(backupFile, []byte(backupJSON), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return backupJSON, nil
+}
+
+// collectBackupModel crawls the CC for everything a snapshot holds; it
+// only reads from the CC. Collections that could not be read, completely
+// or partly, are recorded in report.
+func collectBackupModel(ccAPI util.CCApi, report *failureReport) models.BackupModel {
+	collected := func(collection string, err error) {
+		if err != nil {
+			log.Printf("Could not retrieve %s: %v", collection, err)
+		} else {
+			log.Println(collection, "done")
+		}
+		report.record(collection, "", err)
+	}
+
+	orgQuotas, err := util.GetOrgQuotaDefinitions(ccAPI)
+	collected("org quota definitions", err)
+	spaceQuotas, err := util.GetSpaceQuotaDefinitions(ccAPI)
+	collected("space quota definitions", err)
+	backupResources, err := util.GetOrgsResourcesRecurively(ccAPI)
+	util.AddServiceInstanceParameters(ccAPI, backupResources)
+	packageHashes := util.GetAppPackageHashes(ccAPI, backupResources)
+	droplets := util.GetAppDroplets(ccAPI, backupResources)
+	collected("orgs", err)
+	sharedDomains, err := util.GetSharedDomains(ccAPI)
+	collected("shared domains", err)
+	securityGroups, err := util.GetSecurityGroups(ccAPI)
+	collected("security groups", err)
+	featureFlags, err := util.GetFeatureFlags(ccAPI)
+	collected("feature flags", err)
+	servicePlans, err := util.GetServicePlans(ccAPI)
+	collected("service plans", err)
+	userProvidedServiceInstances, err := util.GetUserProvidedServiceInstances(ccAPI)
+	collected("user provided service instances", err)
+	buildpacks, err := util.GetBuildpacks(ccAPI)
+	collected("buildpacks", err)
+
+	return models.BackupModel{
+		OrgQuotas:      orgQuotas,
+		SpaceQuotas:    spaceQuotas,
+		Organizations:  backupResources,
+		SharedDomains:  sharedDomains,
+		SecurityGroups: securityGroups,
+		FeatureFlags:   featureFlags,
+
+		ServicePlans:                 servicePlans,
+		UserProvidedServiceInstances: userProvidedServiceInstances,
+		Buildpacks:                   buildpacks,
+		PackageHashes:                packageHashes,
+		Droplets:                     droplets,
+	}
+}
+
+// saveDroplets downloads the current droplet of the apps into the droplets
+// directory, skipping the ones saved by a previous snapshot
+func saveDroplets(apps []models.App, droplets map[string]models.Droplet, report *failureReport) error {
+	err := os.MkdirAll(backupDropletsDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = removePartialBits(backupDropletsDir)
+	if err != nil {
+		return err
+	}
+
+	var pending []models.App
+	for _, app := range apps {
+		droplet, staged := droplets[app.GUID]
+		if !staged {
+			continue
+		}
+		if util.BitsUpToDate(filepath.Join(backupDropletsDir, app.GUID+".tgz"), droplet.Checksum) {
+			report.record("droplet", app.Name, nil)
+			continue
+		}
+		pending = append(pending, app)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	log.Printf("Saving droplets for %d apps", len(pending))
+	progress := newTransferProgress(len(pending), true)
+	packager := newTransferPackager(progress)
+	errs := runTransfers(len(pending), transferWorkers, progress, func(i int) error {
+		data, err := packager.GetStagedDroplet(pending[i].GUID)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		return packager.SaveDropletToFile(filepath.Join(backupDropletsDir, pending[i].GUID+".tgz"), data)
+	})
+	progress.finish("Saved droplets")
+
+	for i, app := range pending {
+		if errs[i] != nil {
+			log.Printf("Could not save the droplet of %v: %v", app.GUID, errs[i])
+		}
+		report.record("droplet", app.Name, errs[i])
+	}
+	return nil
 }
 
 func init() {
+	snapshotCmd.Flags().String("archive", "", "Write the snapshot into a single .tgz archive with a checksum manifest")
+	snapshotCmd.Flags().IntVar(&crawlWorkers, "parallel", 4, "Number of CC requests run concurrently while crawling")
+	snapshotCmd.Flags().IntVar(&transferWorkers, "transfers", 4, "Number of app bits downloaded concurrently")
 	RootCmd.AddCommand(snapshotCmd)
 
 	// Here you will define your flags and configuration settings.
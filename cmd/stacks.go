@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// stackMap re-points the apps and buildpacks of the backup from a stack to
+// a replacement stack of the target, e.g. cflinuxfs2 to cflinuxfs3
+type stackMap map[string]string
+
+// target returns the stack of the target that replaces stack
+func (stacks stackMap) target(stack string) string {
+	if replacement, mapped := stacks[stack]; mapped {
+		return replacement
+	}
+	return stack
+}
+
+// stackUsage counts what references a stack of the backup
+type stackUsage struct {
+	apps       int
+	buildpacks int
+}
+
+// checkStacks lists the stacks referenced by the selected apps and
+// buildpacks of the backup, with their replacement, and warns about the
+// ones missing on the target
+func checkStacks(orgs []models.Organization, buildpacks []*models.ResourceModel, selector *restoreSelector, stacks stackMap) error {
+	usages := make(map[string]*stackUsage)
+	use := func(stack string) *stackUsage {
+		if usages[stack] == nil {
+			usages[stack] = &stackUsage{}
+		}
+		return usages[stack]
+	}
+
+	for _, organization := range orgs {
+		for _, sp := range organization.Spaces {
+			for _, application := range sp.Apps {
+				if !application.Docker() && application.Stack != "" && selector.appSelected(organization.Name, sp.Name, application.Name) {
+					use(application.Stack).apps++
+				}
+			}
+		}
+	}
+	for _, bp := range buildpacks {
+		if stack, ok := bp.Entity["stack"].(string); ok && stack != "" {
+			use(stack).buildpacks++
+		}
+	}
+	if len(usages) == 0 {
+		return nil
+	}
+
+	resources, err := util.GetResources(restoreCC, "/v2/stacks", 1)
+	if err != nil {
+		return err
+	}
+	available := make(map[string]bool)
+	for _, stack := range resources {
+		available[fmt.Sprint(stack.Entity["name"])] = true
+	}
+
+	names := make([]string, 0, len(usages))
+	for name := range usages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	log.Print("Stacks referenced by the backup:")
+	for _, name := range names {
+		usage := usages[name]
+		line := fmt.Sprintf("  %s: %d apps, %d buildpacks", name, usage.apps, usage.buildpacks)
+		target := stacks.target(name)
+		if target != name {
+			line += ", restored to " + target
+		}
+		if !available[target] {
+			line += ", MISSING on the target"
+		}
+		log.Print(line)
+	}
+	for _, name := range names {
+		if target := stacks.target(name); !available[target] {
+			showWarning(fmt.Sprintf("Stack %s is missing on the target, the apps and buildpacks using %s will not be restored; use --stack-map %s=STACK to replace it", target, name, name))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func TestCheckStacks_FlagsMissingStacks(t *testing.T) {
+	restoreCC = fakeCC{
+		"/v2/stacks": `{"total_results":1,"total_pages":1,"next_url":null,"resources":[
+			{"metadata":{"guid":"st1","url":"/v2/stacks/st1"},"entity":{"name":"cflinuxfs3"}}]}`,
+	}
+	defer func() { restoreCC = nil }()
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	orgs := []models.Organization{{Name: "o1", Spaces: []models.Space{{Name: "s1", Apps: []models.App{
+		{Name: "a1", Stack: "cflinuxfs2"},
+		{Name: "a2", Stack: "opensuse42"},
+		{Name: "a3", Stack: "cflinuxfs2"},
+	}}}}}
+	buildpacks := []*models.ResourceModel{{Entity: map[string]interface{}{"name": "ruby", "stack": "cflinuxfs2"}}}
+	selector, _ := newRestoreSelector(nil, nil, nil)
+
+	if err := checkStacks(orgs, buildpacks, selector, stackMap{"cflinuxfs2": "cflinuxfs3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"cflinuxfs2: 2 apps, 1 buildpacks, restored to cflinuxfs3\n",
+		"opensuse42: 1 apps, 0 buildpacks, MISSING on the target\n",
+		"WARNING: Stack opensuse42 is missing on the target",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in:\n%s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Stack cflinuxfs3 is missing") {
+		t.Fatalf("the mapped stack exists on the target:\n%s", out.String())
+	}
+}
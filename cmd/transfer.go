@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cheggaaa/pb"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+// transferWorkers is the number of app bits downloaded or uploaded
+// concurrently
+var transferWorkers int
+
+// transferProgress shows the apps whose bits were transferred and the
+// bytes transferred so far, across all workers
+type transferProgress struct {
+	bar   *pb.ProgressBar
+	apps  int64
+	bytes int64
+}
+
+// newTransferProgress starts the progress of transferring the bits of
+// apps; a hidden one only logs the totals once finished
+func newTransferProgress(apps int, visible bool) *transferProgress {
+	progress := &transferProgress{}
+	if visible {
+		progress.bar = pb.New(apps)
+		progress.bar.Output = os.Stderr
+		progress.bar.Start()
+	}
+	return progress
+}
+
+func (progress *transferProgress) transferred(n int) {
+	bytes := atomic.AddInt64(&progress.bytes, int64(n))
+	if progress.bar != nil {
+		progress.bar.Postfix(" " + pb.Format(bytes).To(pb.U_BYTES).String())
+	}
+}
+
+func (progress *transferProgress) appDone() {
+	atomic.AddInt64(&progress.apps, 1)
+	if progress.bar != nil {
+		progress.bar.Increment()
+	}
+}
+
+// finish ends the progress with a summary starting with action, e.g. "Saved
+// bits"
+func (progress *transferProgress) finish(action string) {
+	summary := fmt.Sprintf("%s of %d apps, %s", action, atomic.LoadInt64(&progress.apps),
+		pb.Format(atomic.LoadInt64(&progress.bytes)).To(pb.U_BYTES).String())
+	if progress.bar != nil {
+		progress.bar.FinishPrint(summary)
+		return
+	}
+	log.Print(summary)
+}
+
+// progressReader counts what is read from a transferred file
+type progressReader struct {
+	io.ReadCloser
+	progress *transferProgress
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.progress.transferred(n)
+	return n, err
+}
+
+// progressFileReader counts the bytes of the uploaded files
+type progressFileReader struct {
+	util.FileReader
+	progress *transferProgress
+}
+
+// Open opens filename for an upload counted in the progress
+func (reader *progressFileReader) Open(filename string) (io.ReadCloser, int64, error) {
+	file, size, err := reader.FileReader.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &progressReader{ReadCloser: file, progress: reader.progress}, size, nil
+}
+
+// progressFileWriter counts the bytes of the downloaded files
+type progressFileWriter struct {
+	util.FileWriter
+	progress *transferProgress
+}
+
+// WriteFile saves a download counted in the progress
+func (writer *progressFileWriter) WriteFile(filename string, data io.Reader, perm os.FileMode) error {
+	counted := &progressReader{ReadCloser: ioutil.NopCloser(data), progress: writer.progress}
+	return writer.FileWriter.WriteFile(filename, counted, perm)
+}
+
+// newTransferPackager returns a packager counting the bytes it transfers in
+// progress
+func newTransferPackager(progress *transferProgress) *util.CFPackager {
+	return &util.CFPackager{
+		Cli:    CliConnection,
+		Writer: &progressFileWriter{FileWriter: new(util.CFFileWriter), progress: progress},
+		Reader: &progressFileReader{FileReader: new(util.CFFileReader), progress: progress},
+	}
+}
+
+// runTransfers transfers the bits of count apps on workers concurrent
+// workers and returns the error of each transfer by index
+func runTransfers(count, workers int, progress *transferProgress, transfer func(i int) error) []error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, count)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = transfer(i)
+				progress.appDone()
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunTransfers_BoundsWorkersAndKeepsErrorsByApp(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	progress := newTransferProgress(6, false)
+
+	errs := runTransfers(6, 2, progress, func(i int) error {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+
+		if i%3 == 0 {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+
+	if maxRunning > 2 {
+		t.Fatalf("expected at most 2 concurrent transfers, got %d", maxRunning)
+	}
+	for i, err := range errs {
+		if (err != nil) != (i%3 == 0) {
+			t.Fatalf("unexpected error for app %d: %v", i, err)
+		}
+	}
+	if progress.apps != 6 {
+		t.Fatalf("expected 6 apps done, got %d", progress.apps)
+	}
+}
+
+func TestProgressReader_CountsBytes(t *testing.T) {
+	progress := newTransferProgress(1, false)
+	reader := &progressReader{ReadCloser: ioutil.NopCloser(strings.NewReader("PK droplet")), progress: progress}
+
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+	if progress.bytes != int64(len("PK droplet")) {
+		t.Fatalf("expected %d bytes, got %d", len("PK droplet"), progress.bytes)
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// waitPollInterval is the time between two checks of the apps being started
+var waitPollInterval = 5 * time.Second
+
+// startingApp is a restored app waited for until it runs
+type startingApp struct {
+	entry restoreEntry
+	// status describes the last check of the app
+	status string
+	err    error
+	done   bool
+}
+
+// checkAppStarted tells whether the app with guid is done starting, and
+// the error it failed with if so. The status describes how far it got.
+func checkAppStarted(guid string) (done bool, status string, err error) {
+	resp, err := invokeCC("GET", "/v2/apps/"+guid, nil)
+	if err != nil {
+		return true, "", err
+	}
+	_, obj, err := getResult(resp, "", "")
+	if err != nil {
+		return true, "", err
+	}
+	entity, _ := obj["entity"].(map[string]interface{})
+
+	switch entity["package_state"] {
+	case "FAILED":
+		reason := entity["staging_failed_description"]
+		if reason == nil {
+			reason = entity["staging_failed_reason"]
+		}
+		return true, "staging failed", fmt.Errorf("Staging failed: %v", reason)
+	case "STAGED":
+	default:
+		return false, "staging", nil
+	}
+
+	resp, err = invokeCC("GET", "/v2/apps/"+guid+"/instances", nil)
+	if err != nil {
+		return true, "", err
+	}
+	_, instances, err := getResult(resp, "", "")
+	if ccErr, fromCC := err.(*ccError); fromCC && (ccErr.Code == "CF-StagingInProgress" || ccErr.Code == "CF-NotStaged") {
+		return false, "staging", nil
+	}
+	if err != nil {
+		return true, "", err
+	}
+
+	expected, _ := entity["instances"].(float64)
+	running, crashed := 0, 0
+	for _, instance := range instances {
+		state, _ := instance.(map[string]interface{})
+		switch state["state"] {
+		case "RUNNING":
+			running++
+		case "CRASHED":
+			crashed++
+		}
+	}
+	status = fmt.Sprintf("%d of %v instances running", running, expected)
+	if crashed > 0 {
+		status += fmt.Sprintf(", %d crashed", crashed)
+	}
+	return float64(running) >= expected, status, nil
+}
+
+// waitForApps polls the started apps until each one is running, failed to
+// stage, or timeout elapsed. The apps that did not come back are listed
+// at the end.
+func waitForApps(apps []*pendingApp, timeout time.Duration, report *failureReport) {
+	if len(apps) == 0 {
+		return
+	}
+
+	log.Printf("Waiting for %d apps to start", len(apps))
+	starting := make([]*startingApp, 0, len(apps))
+	for _, app := range apps {
+		entry := app.entry
+		entry.Type, entry.Action = "app_start", actionStarted
+		starting = append(starting, &startingApp{entry: entry})
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		left := 0
+		for _, app := range starting {
+			if app.done {
+				continue
+			}
+			app.done, app.status, app.err = checkAppStarted(app.entry.NewGUID)
+			if !app.done {
+				left++
+				continue
+			}
+			if app.err == nil {
+				showInfo(fmt.Sprintf("App %s is running", app.entry.Key))
+			}
+		}
+		if left == 0 || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(waitPollInterval)
+	}
+
+	var failed []*startingApp
+	for _, app := range starting {
+		if !app.done {
+			app.err = fmt.Errorf("Timed out after %s: %s", timeout, app.status)
+		}
+		if !report.restored(app.entry, app.err) {
+			failed = append(failed, app)
+		}
+	}
+
+	if len(failed) == 0 {
+		log.Printf("All %d apps are running", len(starting))
+		return
+	}
+	log.Printf("%d of %d apps did not come back:", len(failed), len(starting))
+	for _, app := range failed {
+		log.Printf("  %s: %s", app.entry.Key, app.err.Error())
+	}
+}
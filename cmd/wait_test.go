@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForApps_ReportsAppsThatDidNotComeBack(t *testing.T) {
+	restoreCC = fakeCC{
+		"/v2/apps/a1":           `{"metadata":{"guid":"a1"},"entity":{"name":"a1","package_state":"STAGED","instances":2}}`,
+		"/v2/apps/a1/instances": `{"0":{"state":"RUNNING"},"1":{"state":"RUNNING"}}`,
+		"/v2/apps/a2":           `{"metadata":{"guid":"a2"},"entity":{"name":"a2","package_state":"FAILED","staging_failed_reason":"BuildpackCompileFailed","staging_failed_description":"App staging failed in the buildpack compile phase"}}`,
+		"/v2/apps/a3":           `{"metadata":{"guid":"a3"},"entity":{"name":"a3","package_state":"STAGED","instances":1}}`,
+		"/v2/apps/a3/instances": `{"0":{"state":"CRASHED"}}`,
+	}
+	defer func() { restoreCC = nil }()
+	defer func(interval time.Duration) { waitPollInterval = interval }(waitPollInterval)
+	waitPollInterval = time.Millisecond
+
+	var apps []*pendingApp
+	for _, guid := range []string{"a1", "a2", "a3"} {
+		apps = append(apps, &pendingApp{entry: restoreEntry{Type: "app", Key: "o1/s1/" + guid, NewGUID: guid}})
+	}
+	report := &failureReport{}
+	waitForApps(apps, 10*time.Millisecond, report)
+
+	if report.succeeded != 1 || len(report.failures) != 2 {
+		t.Fatalf("expected a1 running and 2 failures, got %d and %+v", report.succeeded, report.failures)
+	}
+	if failure := report.failures[0]; failure.Name != "o1/s1/a2" || !strings.Contains(failure.Err.Error(), "buildpack compile phase") {
+		t.Fatalf("expected the staging error of a2, got %+v", failure)
+	}
+	if failure := report.failures[1]; failure.Name != "o1/s1/a3" || !strings.Contains(failure.Err.Error(), "0 of 1 instances running, 1 crashed") {
+		t.Fatalf("expected a3 to time out crashed, got %+v", failure)
+	}
+}
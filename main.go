@@ -56,6 +56,13 @@ func (c *BackupPlugin) Run(cliConnection plugin.CliConnection, args []string) {
 		}
 	}
 
+	// backup-diff only reads local files and needs no logged in admin
+	if c.argLength > 0 && args[0] == "backup-diff" {
+		cmd.RootCmd.SetArgs(args)
+		cmd.Execute()
+		return
+	}
+
 	bearer, err := commands.GetBearerToken(cliConnection)
 	if err != nil {
 		commands.ShowFailed(fmt.Sprint("ERROR:", err))
@@ -76,6 +83,7 @@ func (c *BackupPlugin) Run(cliConnection plugin.CliConnection, args []string) {
 	}
 
 	cmd.CliConnection = cliConnection
+	cmd.PluginVersion = version
 
 	cmd.RootCmd.SetArgs(args)
 	cmd.Execute()
@@ -84,9 +92,11 @@ func (c *BackupPlugin) Run(cliConnection plugin.CliConnection, args []string) {
 //GetMetadata returns metadata for cf cli
 func (c *BackupPlugin) GetMetadata() plugin.PluginMetadata {
 	helpMessages := map[string]string{
-		"snapshot": "cf backup-snapshot",
-		"restore":  "cf backup-restore [--include-security-groups] [--include-quota-definitions]",
-		"info":     "cf backup-info",
+		"snapshot": "cf backup-snapshot [--dir DIR] [--file FILE] [--bits-dir DIR] [--archive FILE.tgz] [--parallel N] [--transfers N] [--retries N]",
+		"restore":  "cf backup-restore [--dir DIR] [--file FILE] [--bits-dir DIR] [--archive FILE.tgz] [--include-security-groups] [--include-quota-definitions] [--dry-run] [--report FILE.json] [--resume] [--stack-map OLD=NEW]... [--stack-map-file FILE] [--domain-map OLD=NEW]... [--domain-map-file FILE] [--restage] [--wait] [--wait-timeout DURATION] [--transfers N] [--retries N] [--org ORG]... [--space ORG/SPACE]... [--app ORG/SPACE/APP]...",
+		"info":     "cf backup-info [--dir DIR] [--file FILE] [--bits-dir DIR] [--archive FILE.tgz]",
+		"diff":     "cf backup-diff OLD_BACKUP NEW_BACKUP [--json]",
+		"drift":    "cf backup-drift [--dir DIR] [--file FILE] [--json] [--parallel N] [--retries N]",
 	}
 	summary := ""
 	for _, value := range helpMessages {
@@ -132,6 +142,20 @@ func (c *BackupPlugin) GetMetadata() plugin.PluginMetadata {
 					Usage: helpMessages["info"],
 				},
 			},
+			plugin.Command{
+				Name:     "backup-diff",
+				HelpText: "Compare two backup snapshots",
+				UsageDetails: plugin.Usage{
+					Usage: helpMessages["diff"],
+				},
+			},
+			plugin.Command{
+				Name:     "backup-drift",
+				HelpText: "Compare the current snapshot with the live CloudFoundry",
+				UsageDetails: plugin.Usage{
+					Usage: helpMessages["drift"],
+				},
+			},
 		},
 	}
 }
@@ -0,0 +1,468 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError reports a backup field that is missing or has an
+// unexpected type
+type ValidationError struct {
+	Field   string
+	Problem string
+}
+
+func (err *ValidationError) Error() string {
+	return fmt.Sprintf("invalid backup: %s %s", err.Field, err.Problem)
+}
+
+// SkippedResource is an app, or a route of an app, that was left out
+// because a relation it needs was not captured: the crawler leaves out the
+// relations it failed to retrieve
+type SkippedResource struct {
+	// Kind is "app" or "route"
+	Kind         string
+	Organization string
+	Space        string
+	App          string
+	// Field is the path of the missing relation in the backup
+	Field string
+}
+
+// Key names the app as org/space/app
+func (skipped *SkippedResource) Key() string {
+	return skipped.Organization + "/" + skipped.Space + "/" + skipped.App
+}
+
+func (skipped *SkippedResource) Error() string {
+	return skipped.Field + " was not captured"
+}
+
+// SkippedError is returned together with the organizations that were read
+// when some of their apps or routes had to be left out
+type SkippedError struct {
+	Skipped []*SkippedResource
+}
+
+func (err *SkippedError) Error() string {
+	messages := make([]string, 0, len(err.Skipped))
+	for _, skipped := range err.Skipped {
+		messages = append(messages, skipped.Kind+" "+skipped.Key()+": "+skipped.Error())
+	}
+	return fmt.Sprintf("left out %d resources: %s", len(err.Skipped), strings.Join(messages, "; "))
+}
+
+// entityReader reads typed fields of a resource and remembers the first
+// field that could not be read; readers of nested resources share it, and
+// the resources left out for a missing relation
+type entityReader struct {
+	path    string
+	model   *ResourceModel
+	err     *error
+	skipped *[]*SkippedResource
+}
+
+func newEntityReader(path string, resource *ResourceModel) *entityReader {
+	var err error
+	var skipped []*SkippedResource
+	reader := &entityReader{path: path, model: resource, err: &err, skipped: &skipped}
+	if resource == nil || resource.Metadata == nil || resource.Entity == nil {
+		reader.fail("", "is missing")
+	}
+	return reader
+}
+
+func (reader *entityReader) child(field string, resource *ResourceModel) *entityReader {
+	child := &entityReader{path: reader.path + "." + field, model: resource, err: reader.err, skipped: reader.skipped}
+	if resource == nil || resource.Entity == nil {
+		child.fail("", "is missing")
+	}
+	return child
+}
+
+func (reader *entityReader) fail(field, problem string) {
+	if *reader.err != nil {
+		return
+	}
+	path := reader.path
+	if field != "" {
+		path += "." + field
+	}
+	*reader.err = &ValidationError{Field: path, Problem: problem}
+}
+
+// captured tells whether a relation was followed. The crawler leaves out
+// the relations it failed to retrieve, while a null relation is kept.
+func (reader *entityReader) captured(field string) bool {
+	if reader.model == nil || reader.model.Entity == nil {
+		return true
+	}
+	_, found := reader.model.Entity[field]
+	return found
+}
+
+// skip records that a resource of the app named by owner is left out
+// because its relation field was not captured
+func (reader *entityReader) skip(kind string, owner SkippedResource, field string) {
+	owner.Kind = kind
+	owner.Field = reader.path + "." + field
+	*reader.skipped = append(*reader.skipped, &owner)
+}
+
+func (reader *entityReader) value(field string) interface{} {
+	if reader.model == nil || reader.model.Entity == nil {
+		return nil
+	}
+	return reader.model.Entity[field]
+}
+
+func (reader *entityReader) guid() string {
+	if reader.model == nil {
+		return ""
+	}
+	guid, ok := reader.model.Metadata["guid"].(string)
+	if !ok {
+		reader.fail("metadata.guid", "is not a string")
+	}
+	return guid
+}
+
+func (reader *entityReader) string(field string) string {
+	value, ok := reader.value(field).(string)
+	if !ok {
+		reader.fail(field, "is not a string")
+	}
+	return value
+}
+
+func (reader *entityReader) optionalString(field string) *string {
+	if reader.value(field) == nil {
+		return nil
+	}
+	value := reader.string(field)
+	return &value
+}
+
+func (reader *entityReader) bool(field string) bool {
+	value, ok := reader.value(field).(bool)
+	if !ok {
+		reader.fail(field, "is not a boolean")
+	}
+	return value
+}
+
+func (reader *entityReader) int(field string) int {
+	value, ok := reader.value(field).(float64)
+	if !ok || value != float64(int(value)) {
+		reader.fail(field, "is not an integer")
+	}
+	return int(value)
+}
+
+func (reader *entityReader) optionalInt(field string) *int {
+	if reader.value(field) == nil {
+		return nil
+	}
+	value := reader.int(field)
+	return &value
+}
+
+func (reader *entityReader) list(field string) []interface{} {
+	if reader.value(field) == nil {
+		return nil
+	}
+	value, ok := reader.value(field).([]interface{})
+	if !ok {
+		reader.fail(field, "is not a list")
+	}
+	return value
+}
+
+func (reader *entityReader) object(field string) map[string]interface{} {
+	if reader.value(field) == nil {
+		return nil
+	}
+	value, ok := reader.value(field).(map[string]interface{})
+	if !ok {
+		reader.fail(field, "is not an object")
+	}
+	return value
+}
+
+func (reader *entityReader) resource(field string) *ResourceModel {
+	value, ok := reader.value(field).(*ResourceModel)
+	if !ok {
+		reader.fail(field, "is not a resource")
+	}
+	return value
+}
+
+// resources returns a followed relation; relations that were not captured
+// are empty
+func (reader *entityReader) resources(field string) []*ResourceModel {
+	if reader.value(field) == nil {
+		return nil
+	}
+	value, ok := reader.value(field).(*[]*ResourceModel)
+	if !ok || value == nil {
+		reader.fail(field, "is not a list of resources")
+		return nil
+	}
+	return *value
+}
+
+func (reader *entityReader) each(field string, convert func(reader *entityReader)) {
+	for i, resource := range reader.resources(field) {
+		convert(reader.child(fmt.Sprintf("%s[%d]", field, i), resource))
+	}
+}
+
+func readUserRoles(reader *entityReader, field string) []UserRole {
+	var roles []UserRole
+	reader.each(field, func(user *entityReader) {
+		roles = append(roles, UserRole{GUID: user.guid(), Username: user.string("username")})
+	})
+	return roles
+}
+
+func readDomain(reader *entityReader) Domain {
+	domain := Domain{GUID: reader.guid(), Name: reader.string("name")}
+	if owner := reader.optionalString("owning_organization_guid"); owner != nil {
+		domain.OwningOrganizationGUID = *owner
+	}
+	return domain
+}
+
+func readRoute(reader *entityReader) Route {
+	route := Route{
+		GUID:   reader.guid(),
+		Port:   reader.optionalInt("port"),
+		Domain: readDomain(reader.child("domain", reader.resource("domain"))),
+	}
+	if host := reader.optionalString("host"); host != nil {
+		route.Host = *host
+	}
+	if path := reader.optionalString("path"); path != nil {
+		route.Path = *path
+	}
+	return route
+}
+
+// readApp reads an app of the named space. Apps without their stack and
+// routes without their domain are left out, as they cannot be restored; ok
+// is false when the app is.
+func readApp(reader *entityReader, organizationName, spaceName string) (app App, ok bool) {
+	app = App{
+		GUID:               reader.guid(),
+		Name:               reader.string("name"),
+		State:              reader.string("state"),
+		Buildpack:          reader.optionalString("buildpack"),
+		Command:            reader.optionalString("command"),
+		Memory:             reader.int("memory"),
+		Instances:          reader.int("instances"),
+		DiskQuota:          reader.int("disk_quota"),
+		HealthCheckType:    reader.optionalString("health_check_type"),
+		HealthCheckTimeout: reader.optionalInt("health_check_timeout"),
+		EnvironmentJSON:    reader.object("environment_json"),
+	}
+	if diego, ok := reader.value("diego").(bool); ok {
+		app.Diego = diego
+	}
+	if enableSSH, ok := reader.value("enable_ssh").(bool); ok {
+		app.EnableSSH = enableSSH
+	}
+	if image := reader.optionalString("docker_image"); image != nil {
+		app.DockerImage = *image
+	}
+	owner := SkippedResource{Organization: organizationName, Space: spaceName, App: app.Name}
+	if !app.Docker() {
+		if !reader.captured("stack") {
+			reader.skip("app", owner, "stack")
+			return app, false
+		}
+		app.Stack = reader.child("stack", reader.resource("stack")).string("name")
+	}
+	for i, port := range reader.list("ports") {
+		number, ok := port.(float64)
+		if !ok {
+			reader.fail(fmt.Sprintf("ports[%d]", i), "is not an integer")
+		}
+		app.Ports = append(app.Ports, int(number))
+	}
+	reader.each("routes", func(route *entityReader) {
+		if !route.captured("domain") {
+			route.skip("route", owner, "domain")
+			return
+		}
+		app.Routes = append(app.Routes, readRoute(route))
+	})
+	reader.each("service_bindings", func(binding *entityReader) {
+		app.ServiceBindings = append(app.ServiceBindings, binding.string("service_instance_guid"))
+	})
+	return app, true
+}
+
+func readSpace(reader *entityReader, organizationName string) Space {
+	space := Space{
+		GUID:             reader.guid(),
+		Name:             reader.string("name"),
+		OrganizationName: organizationName,
+		Developers:       readUserRoles(reader, "developers"),
+		Managers:         readUserRoles(reader, "managers"),
+		Auditors:         readUserRoles(reader, "auditors"),
+		ServiceInstances: reader.resources("service_instances"),
+	}
+	if quota := reader.optionalString("space_quota_definition_guid"); quota != nil {
+		space.SpaceQuotaGUID = *quota
+	}
+	if organization, ok := reader.value("organization").(*ResourceModel); ok && organization.Entity != nil && space.OrganizationName == "" {
+		space.OrganizationName = reader.child("organization", organization).string("name")
+	}
+	reader.each("apps", func(app *entityReader) {
+		if converted, ok := readApp(app, space.OrganizationName, space.Name); ok {
+			space.Apps = append(space.Apps, converted)
+		}
+	})
+	return space
+}
+
+func readOrganization(reader *entityReader) Organization {
+	organization := Organization{
+		GUID:            reader.guid(),
+		Name:            reader.string("name"),
+		Managers:        readUserRoles(reader, "managers"),
+		Auditors:        readUserRoles(reader, "auditors"),
+		BillingManagers: readUserRoles(reader, "billing_managers"),
+	}
+	if quota := reader.optionalString("quota_definition_guid"); quota != nil {
+		organization.QuotaGUID = *quota
+	}
+	reader.each("private_domains", func(domain *entityReader) {
+		organization.PrivateDomains = append(organization.PrivateDomains, readDomain(domain))
+	})
+	reader.each("spaces", func(space *entityReader) {
+		organization.Spaces = append(organization.Spaces, readSpace(space, organization.Name))
+	})
+	return organization
+}
+
+// NewOrganizations converts the crawled org resource graph into typed orgs
+// NewOrganizations converts organization resources with their spaces and
+// apps. Apps and routes left out for a relation that was not captured are
+// reported by a SkippedError returned together with the organizations.
+func NewOrganizations(resources []*ResourceModel) ([]Organization, error) {
+	var organizations []Organization
+	var skipped []*SkippedResource
+	for i, resource := range resources {
+		reader := newEntityReader(fmt.Sprintf("organizations[%d]", i), resource)
+		organization := readOrganization(reader)
+		if *reader.err != nil {
+			return nil, *reader.err
+		}
+		organizations = append(organizations, organization)
+		skipped = append(skipped, *reader.skipped...)
+	}
+	if len(skipped) > 0 {
+		return organizations, &SkippedError{Skipped: skipped}
+	}
+	return organizations, nil
+}
+
+// NewServiceInstance converts a managed service instance resource. The
+// instances are converted one by one, so that a bad one is reported on its
+// own; path names it in the error.
+func NewServiceInstance(path string, resource *ResourceModel) (ServiceInstance, error) {
+	reader := newEntityReader(path, resource)
+	instance := ServiceInstance{
+		GUID:            reader.guid(),
+		Name:            reader.string("name"),
+		ServicePlanGUID: reader.string("service_plan_guid"),
+		Parameters:      reader.object("parameters"),
+		Tags:            reader.list("tags"),
+	}
+	reader.each("service_keys", func(key *entityReader) {
+		instance.Keys = append(instance.Keys, ServiceKey{GUID: key.guid(), Name: key.string("name")})
+	})
+	return instance, *reader.err
+}
+
+// NewUserProvidedServiceInstance converts a user provided service instance
+// resource, the same way as NewServiceInstance
+func NewUserProvidedServiceInstance(path string, resource *ResourceModel) (UserProvidedServiceInstance, error) {
+	reader := newEntityReader(path, resource)
+	instance := UserProvidedServiceInstance{
+		GUID:            reader.guid(),
+		Name:            reader.string("name"),
+		SpaceGUID:       reader.string("space_guid"),
+		Credentials:     reader.object("credentials"),
+		SyslogDrainURL:  reader.optionalString("syslog_drain_url"),
+		RouteServiceURL: reader.optionalString("route_service_url"),
+		Tags:            reader.list("tags"),
+	}
+	return instance, *reader.err
+}
+
+// NewQuotas converts org or space quota definition resources into quotas
+func NewQuotas(collection string, resources []*ResourceModel) ([]Quota, error) {
+	var quotas []Quota
+	for i, resource := range resources {
+		reader := newEntityReader(fmt.Sprintf("%s[%d]", collection, i), resource)
+		quota := Quota{
+			GUID:                    reader.guid(),
+			Name:                    reader.string("name"),
+			NonBasicServicesAllowed: reader.bool("non_basic_services_allowed"),
+			TotalServices:           reader.int("total_services"),
+			TotalRoutes:             reader.int("total_routes"),
+			MemoryLimit:             reader.int("memory_limit"),
+			InstanceMemoryLimit:     reader.optionalInt("instance_memory_limit"),
+			AppInstanceLimit:        reader.optionalInt("app_instance_limit"),
+			TotalReservedRoutePorts: reader.optionalInt("total_reserved_route_ports"),
+			TotalServiceKeys:        reader.optionalInt("total_service_keys"),
+		}
+		if organization := reader.optionalString("organization_guid"); organization != nil {
+			quota.OrganizationGUID = *organization
+		}
+		if *reader.err != nil {
+			return nil, *reader.err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+// NewDomains converts shared domain resources into domains
+func NewDomains(resources []*ResourceModel) ([]Domain, error) {
+	var domains []Domain
+	for i, resource := range resources {
+		reader := newEntityReader(fmt.Sprintf("shared_domains[%d]", i), resource)
+		domain := readDomain(reader)
+		if *reader.err != nil {
+			return nil, *reader.err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// NewSecurityGroups converts security group resources into security groups
+func NewSecurityGroups(resources []*ResourceModel) ([]SecurityGroup, error) {
+	var groups []SecurityGroup
+	for i, resource := range resources {
+		reader := newEntityReader(fmt.Sprintf("security_groups[%d]", i), resource)
+		group := SecurityGroup{
+			GUID:           reader.guid(),
+			Name:           reader.string("name"),
+			Rules:          reader.list("rules"),
+			RunningDefault: reader.bool("running_default"),
+			StagingDefault: reader.bool("staging_default"),
+		}
+		reader.each("spaces", func(space *entityReader) {
+			group.Spaces = append(group.Spaces, readSpace(space, ""))
+		})
+		if *reader.err != nil {
+			return nil, *reader.err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
@@ -0,0 +1,200 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+func resource(guid string, entity map[string]interface{}) *models.ResourceModel {
+	return &models.ResourceModel{Metadata: map[string]interface{}{"guid": guid}, Entity: entity}
+}
+
+func resources(list ...*models.ResourceModel) *[]*models.ResourceModel {
+	return &list
+}
+
+func testOrg(app *models.ResourceModel) *models.ResourceModel {
+	return resource("o1", map[string]interface{}{
+		"name":     "org",
+		"managers": resources(resource("u1", map[string]interface{}{"username": "admin"})),
+		"spaces": resources(resource("s1", map[string]interface{}{
+			"name": "space",
+			"apps": resources(app),
+		})),
+	})
+}
+
+func testApp() map[string]interface{} {
+	return map[string]interface{}{
+		"name":       "web",
+		"state":      "STARTED",
+		"memory":     float64(256),
+		"instances":  float64(2),
+		"disk_quota": float64(1024),
+		"command":    nil,
+		"ports":      []interface{}{float64(8080)},
+		"stack":      resource("st", map[string]interface{}{"name": "cflinuxfs2"}),
+		"routes": resources(resource("r1", map[string]interface{}{
+			"host":   "web",
+			"domain": resource("d1", map[string]interface{}{"name": "example.com"}),
+		})),
+		"service_bindings": resources(resource("b1", map[string]interface{}{"service_instance_guid": "si1"})),
+	}
+}
+
+func TestNewOrganizations(t *testing.T) {
+	orgs, err := models.NewOrganizations([]*models.ResourceModel{testOrg(resource("a1", testApp()))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orgs) != 1 || orgs[0].Name != "org" || orgs[0].Managers[0].Username != "admin" {
+		t.Fatalf("unexpected orgs %+v", orgs)
+	}
+	space := orgs[0].Spaces[0]
+	if space.Name != "space" || space.OrganizationName != "org" {
+		t.Fatalf("unexpected space %+v", space)
+	}
+	app := space.Apps[0]
+	if app.GUID != "a1" || app.Memory != 256 || app.Stack != "cflinuxfs2" || app.Command != nil || app.Ports[0] != 8080 {
+		t.Fatalf("unexpected app %+v", app)
+	}
+	if len(app.Routes) != 1 || app.Routes[0].Host != "web" || !app.Routes[0].Domain.Shared() {
+		t.Fatalf("unexpected routes %+v", app.Routes)
+	}
+	if len(app.ServiceBindings) != 1 || app.ServiceBindings[0] != "si1" {
+		t.Fatalf("unexpected service bindings %v", app.ServiceBindings)
+	}
+}
+
+func TestNewOrganizations_NamesTheBadField(t *testing.T) {
+	cases := map[string]func(app map[string]interface{}){
+		"organizations[0].spaces[0].apps[0].stack is not a resource": func(app map[string]interface{}) {
+			app["stack"] = nil
+		},
+		"organizations[0].spaces[0].apps[0].memory is not an integer": func(app map[string]interface{}) {
+			app["memory"] = "256M"
+		},
+		"organizations[0].spaces[0].apps[0].routes[0].domain.name is not a string": func(app map[string]interface{}) {
+			app["routes"] = resources(resource("r1", map[string]interface{}{
+				"host":   "web",
+				"domain": resource("d1", map[string]interface{}{}),
+			}))
+		},
+	}
+
+	for expected, breakApp := range cases {
+		app := testApp()
+		breakApp(app)
+
+		_, err := models.NewOrganizations([]*models.ResourceModel{testOrg(resource("a1", app))})
+		if err == nil || err.Error() != "invalid backup: "+expected {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestNewOrganizations_DockerAppsNeedNoStack(t *testing.T) {
+	app := testApp()
+	app["stack"] = nil
+	app["docker_image"] = "busybox"
+
+	orgs, err := models.NewOrganizations([]*models.ResourceModel{testOrg(resource("a1", app))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !orgs[0].Spaces[0].Apps[0].Docker() {
+		t.Fatal("app should be a docker app")
+	}
+}
+
+func TestNewServiceInstance(t *testing.T) {
+	instance, err := models.NewServiceInstance("service_instances[0]", resource("si1", map[string]interface{}{
+		"name":              "db",
+		"service_plan_guid": "p1",
+		"tags":              []interface{}{"sql"},
+		"service_keys":      resources(resource("k1", map[string]interface{}{"name": "ci"})),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instance.Name != "db" || instance.ServicePlanGUID != "p1" || instance.Parameters != nil ||
+		len(instance.Keys) != 1 || instance.Keys[0].Name != "ci" {
+		t.Fatalf("unexpected service instance %+v", instance)
+	}
+
+	_, err = models.NewServiceInstance("service_instances[1]", resource("si2", map[string]interface{}{
+		"name":              "cache",
+		"service_plan_guid": nil,
+	}))
+	if err == nil || err.Error() != "invalid backup: service_instances[1].service_plan_guid is not a string" {
+		t.Fatal("expected the null plan to be reported, got", err)
+	}
+}
+
+func TestNewQuotas_KeepsTheOptionalLimits(t *testing.T) {
+	quotas, err := models.NewQuotas("org_quotas", []*models.ResourceModel{
+		resource("q1", map[string]interface{}{
+			"name":                       "default",
+			"non_basic_services_allowed": true,
+			"total_services":             100.0,
+			"total_routes":               1000.0,
+			"memory_limit":               10240.0,
+			"instance_memory_limit":      -1.0,
+			"app_instance_limit":         25.0,
+			"total_reserved_route_ports": 0.0,
+			"total_service_keys":         -1.0,
+		}),
+		resource("q2", map[string]interface{}{
+			"name":                       "legacy",
+			"non_basic_services_allowed": false,
+			"total_services":             10.0,
+			"total_routes":               10.0,
+			"memory_limit":               1024.0,
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := quotas[0]
+	if full.InstanceMemoryLimit == nil || *full.InstanceMemoryLimit != -1 ||
+		full.AppInstanceLimit == nil || *full.AppInstanceLimit != 25 ||
+		full.TotalReservedRoutePorts == nil || *full.TotalReservedRoutePorts != 0 ||
+		full.TotalServiceKeys == nil || *full.TotalServiceKeys != -1 {
+		t.Fatalf("unexpected limits %+v", full)
+	}
+	legacy := quotas[1]
+	if legacy.InstanceMemoryLimit != nil || legacy.AppInstanceLimit != nil ||
+		legacy.TotalReservedRoutePorts != nil || legacy.TotalServiceKeys != nil {
+		t.Fatalf("expected missing limits to stay nil, got %+v", legacy)
+	}
+}
+
+func TestNewOrganizations_LeavesOutUncapturedRelations(t *testing.T) {
+	noStack := testApp()
+	delete(noStack, "stack")
+	noDomain := testApp()
+	noDomain["name"] = "api"
+	noDomain["routes"] = resources(resource("r2", map[string]interface{}{"host": "api"}))
+
+	org := testOrg(resource("a1", noStack))
+	space := (*org.Entity["spaces"].(*[]*models.ResourceModel))[0]
+	space.Entity["apps"] = resources(resource("a1", noStack), resource("a2", noDomain))
+
+	orgs, err := models.NewOrganizations([]*models.ResourceModel{org})
+	skippedErr, partial := err.(*models.SkippedError)
+	if !partial {
+		t.Fatalf("expected a skipped error, got %v", err)
+	}
+	expected := "left out 2 resources: app org/space/web: organizations[0].spaces[0].apps[0].stack was not captured; " +
+		"route org/space/api: organizations[0].spaces[0].apps[1].routes[0].domain was not captured"
+	if skippedErr.Error() != expected {
+		t.Fatalf("unexpected skipped resources %q", skippedErr.Error())
+	}
+
+	apps := orgs[0].Spaces[0].Apps
+	if len(apps) != 1 || apps[0].Name != "api" || len(apps[0].Routes) != 0 {
+		t.Fatalf("expected only the app without a stack to be left out, got %+v", apps)
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // ResourceCollectionModel represents the paged response of an api call
 type ResourceCollectionModel struct {
 	TotalResults int               `json:"total_results"`
@@ -15,14 +17,45 @@ type ResourceModel struct {
 	Entity   map[string]interface{} `json:"entity"`
 }
 
+// BackupMetadataModel describes where, when and by whom a backup was taken
+type BackupMetadataModel struct {
+	APIEndpoint   string    `json:"api_endpoint"`
+	APIVersion    string    `json:"api_version"`
+	PluginVersion string    `json:"plugin_version"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	User          string    `json:"user"`
+	ResourceTypes []string  `json:"resource_types"`
+}
+
 // BackupModel represents the backup json model
 type BackupModel struct {
+	FormatVersion int                  `json:"format_version"`
+	Metadata      *BackupMetadataModel `json:"metadata,omitempty"`
+
 	OrgQuotas      interface{} `json:"org_quota_definitions"`
 	SpaceQuotas    interface{} `json:"space_quota_definitions"`
 	Organizations  interface{} `json:"organizations"`
 	SharedDomains  interface{} `json:"shared_domains"`
 	SecurityGroups interface{} `json:"security_groups"`
 	FeatureFlags   interface{} `json:"feature_flags"`
+
+	ServicePlans                 interface{} `json:"service_plans,omitempty"`
+	UserProvidedServiceInstances interface{} `json:"user_provided_service_instances,omitempty"`
+	Buildpacks                   interface{} `json:"buildpacks,omitempty"`
+
+	// PackageHashes maps app guids to the SHA-256 checksum of their bits
+	PackageHashes map[string]string `json:"package_hashes,omitempty"`
+	// Droplets maps app guids to their current droplet
+	Droplets map[string]Droplet `json:"droplets,omitempty"`
+}
+
+// Droplet is the staged droplet of an app, saved besides its bits
+type Droplet struct {
+	GUID string `json:"guid"`
+	// Checksum is the SHA-256 checksum of the droplet, if the CC has one
+	Checksum     string            `json:"checksum,omitempty"`
+	ProcessTypes map[string]string `json:"process_types,omitempty"`
 }
 
 // FeatureFlagModel represents the feature flag json model
@@ -0,0 +1,152 @@
+package models
+
+import "fmt"
+
+// UserRole is a user holding a role in an org or a space
+type UserRole struct {
+	GUID     string
+	Username string
+}
+
+// Quota is an org or space quota definition. OrganizationGUID is only set
+// for space quotas. The limits older CCs do not have are nil when missing.
+type Quota struct {
+	GUID                    string
+	Name                    string
+	OrganizationGUID        string
+	NonBasicServicesAllowed bool
+	TotalServices           int
+	TotalRoutes             int
+	MemoryLimit             int
+	InstanceMemoryLimit     *int
+	AppInstanceLimit        *int
+	TotalReservedRoutePorts *int
+	TotalServiceKeys        *int
+}
+
+// Domain is a shared domain, or a private domain when OwningOrganizationGUID
+// is set
+type Domain struct {
+	GUID                   string
+	Name                   string
+	OwningOrganizationGUID string
+}
+
+// Shared is true for shared domains
+func (domain Domain) Shared() bool {
+	return domain.OwningOrganizationGUID == ""
+}
+
+// Route is a route mapped to an app
+type Route struct {
+	GUID   string
+	Host   string
+	Path   string
+	Port   *int
+	Domain Domain
+}
+
+// Key returns the natural key of the route: host.domain[:port]/path
+func (route Route) Key() string {
+	key := route.Domain.Name
+	if route.Host != "" {
+		key = route.Host + "." + key
+	}
+	if route.Port != nil {
+		key += fmt.Sprintf(":%d", *route.Port)
+	}
+	return key + route.Path
+}
+
+// App is an application with its settings, routes and service bindings.
+// Nullable settings are pointers, nil when the CC returned null.
+type App struct {
+	GUID               string
+	Name               string
+	State              string
+	Stack              string
+	DockerImage        string
+	Buildpack          *string
+	Command            *string
+	Memory             int
+	Instances          int
+	DiskQuota          int
+	Diego              bool
+	EnableSSH          bool
+	HealthCheckType    *string
+	HealthCheckTimeout *int
+	Ports              []int
+	EnvironmentJSON    map[string]interface{}
+	Routes             []Route
+	// ServiceBindings holds the guids of the bound service instances
+	ServiceBindings []string
+}
+
+// Docker is true for apps running a docker image
+func (app App) Docker() bool {
+	return app.DockerImage != ""
+}
+
+// Space is a space with its roles and apps. OrganizationName is only known
+// when the space was captured below its org or with its org embedded.
+type Space struct {
+	GUID             string
+	Name             string
+	OrganizationName string
+	SpaceQuotaGUID   string
+	Developers       []UserRole
+	Managers         []UserRole
+	Auditors         []UserRole
+	Apps             []App
+	// ServiceInstances are the managed service instances of the space
+	ServiceInstances []*ResourceModel
+}
+
+// ServiceInstance is a managed service instance with its service keys
+type ServiceInstance struct {
+	GUID            string
+	Name            string
+	ServicePlanGUID string
+	Parameters      map[string]interface{}
+	Tags            []interface{}
+	Keys            []ServiceKey
+}
+
+// ServiceKey is a service key of a managed service instance
+type ServiceKey struct {
+	GUID string
+	Name string
+}
+
+// UserProvidedServiceInstance is a user provided service instance
+type UserProvidedServiceInstance struct {
+	GUID            string
+	Name            string
+	SpaceGUID       string
+	Credentials     map[string]interface{}
+	SyslogDrainURL  *string
+	RouteServiceURL *string
+	Tags            []interface{}
+}
+
+// Organization is an org with its roles, private domains and spaces
+type Organization struct {
+	GUID            string
+	Name            string
+	QuotaGUID       string
+	Managers        []UserRole
+	Auditors        []UserRole
+	BillingManagers []UserRole
+	PrivateDomains  []Domain
+	Spaces          []Space
+}
+
+// SecurityGroup is a security group and the spaces it is bound to
+type SecurityGroup struct {
+	GUID           string
+	Name           string
+	Rules          []interface{}
+	RunningDefault bool
+	StagingDefault bool
+	Spaces         []Space
+}
@@ -0,0 +1,116 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// accessToken is the access token of the cf cli, fetched on first use and
+// refreshed when the CC rejects it. It is safe for concurrent use.
+type accessToken struct {
+	lock  sync.Mutex
+	value string
+	// err is the failed refresh; no request is sent anymore after it
+	err error
+}
+
+func (token *accessToken) get(cli plugin.CliConnection) (string, error) {
+	token.lock.Lock()
+	defer token.lock.Unlock()
+
+	if token.err != nil {
+		return "", token.err
+	}
+	if token.value == "" {
+		value, err := cli.AccessToken()
+		if err != nil {
+			return "", err
+		}
+		token.value = value
+	}
+	return token.value, nil
+}
+
+// refresh gets a new token from the cf cli, which refreshes it with the
+// UAA, unless another request did so since stale was handed out
+func (token *accessToken) refresh(cli plugin.CliConnection, stale string) (string, error) {
+	token.lock.Lock()
+	defer token.lock.Unlock()
+
+	if token.err != nil {
+		return "", token.err
+	}
+	if token.value != stale {
+		return token.value, nil
+	}
+
+	log.Println("The access token expired, refreshing it")
+	value, err := cli.AccessToken()
+	if err != nil {
+		token.err = fmt.Errorf("Could not refresh the access token: %s", err.Error())
+		return "", token.err
+	}
+	token.value = value
+	return value, nil
+}
+
+// failure returns the error of a failed refresh, if any
+func (token *accessToken) failure() error {
+	token.lock.Lock()
+	defer token.lock.Unlock()
+
+	return token.err
+}
+
+// invalidAuthToken tells whether the CC rejected the token of a request.
+// The body of resp stays readable.
+func invalidAuthToken(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	var ccErr struct {
+		ErrorCode string `json:"error_code"`
+	}
+	return json.Unmarshal(body, &ccErr) == nil && ccErr.ErrorCode == "CF-InvalidAuthToken"
+}
+
+// send sends the request built by newRequest for the current token,
+// following the retry policy. When the CC rejects the token, it is
+// refreshed and the request is sent again.
+func (token *accessToken) send(cli plugin.CliConnection, client *http.Client, policy RetryPolicy, newRequest func(token string) (*http.Request, error)) (*http.Response, error) {
+	used, err := token.get(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := policy.send(client, func() (*http.Request, error) {
+		return newRequest(used)
+	})
+	if err != nil || !invalidAuthToken(resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	refreshed, err := token.refresh(cli, used)
+	if err != nil {
+		return nil, err
+	}
+	return policy.send(client, func() (*http.Request, error) {
+		return newRequest(refreshed)
+	})
+}
@@ -3,11 +3,9 @@ package util
 // Source: https://github.com/krujos/download_droplet_plugin // Apache 2.0 License
 
 import (
-	"bytes"
-	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -21,95 +19,138 @@ type CFPackager struct {
 	Cli    plugin.CliConnection
 	Writer FileWriter
 	Reader FileReader
+
+	token accessToken
 }
 
-//Packager interaface for implementing downloaders.
+//Packager interaface for implementing downloaders. Bits are streamed, the
+//readers returned by GetDroplet and GetBuildpack have to be closed.
 type Packager interface {
-	GetDroplet(guid string) ([]byte, error)
-	SaveDropletToFile(filePath string, data []byte) error
+	GetDroplet(guid string) (io.ReadCloser, error)
+	SaveDropletToFile(filePath string, data io.Reader) error
 	UploadDroplet(guid, path string) error
+	GetStagedDroplet(appGUID string) (io.ReadCloser, error)
+	UploadStagedDroplet(dropletGUID, path string) error
+	GetBuildpack(guid string) (io.ReadCloser, error)
+	UploadBuildpack(guid, path, filename string) error
 }
 
 //FileWriter test shim for writing to a file.
 type FileWriter interface {
-	WriteFile(filename string, data []byte, perm os.FileMode) error
+	WriteFile(filename string, data io.Reader, perm os.FileMode) error
 }
 
-//CFFileWriter is a wrapper for ioutil.WriteFile
+//CFFileWriter writes files through a temporary file, renamed once complete
 type CFFileWriter struct {
 }
 
-//WriteFile to disk
-func (fw *CFFileWriter) WriteFile(filename string, data []byte, perm os.FileMode) error {
-	return ioutil.WriteFile(filename, data, perm)
+// partialFileSuffix marks the temporary files of CFFileWriter
+const partialFileSuffix = ".part"
+
+//WriteFile streams data to disk. The temporary file is written next to
+//filename, so that the rename is atomic and filename never holds a
+//partial file.
+func (fw *CFFileWriter) WriteFile(filename string, data io.Reader, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+partialFileSuffix)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
 }
 
 //FileReader test shim for reading a file.
 type FileReader interface {
-	ReadFile(filename string) ([]byte, error)
+	// Open opens filename for reading and returns its size
+	Open(filename string) (io.ReadCloser, int64, error)
 }
 
-//CFFileReader is a wrapper for ioutil.ReadFile
+//CFFileReader is a wrapper for os.Open
 type CFFileReader struct {
 }
 
-//ReadFile reads a file
-func (fr *CFFileReader) ReadFile(filename string) ([]byte, error) {
-	return ioutil.ReadFile(filename)
+//Open opens a file
+func (fr *CFFileReader) Open(filename string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
 }
 
 func (packager *CFPackager) makeHTTPClient() (*http.Client, error) {
-	sslDisabled, err := packager.Cli.IsSSLDisabled()
-	if nil != err {
-		return nil, err
-	}
+	return newCCHTTPClient(packager.Cli)
+}
 
-	tlsConfig := &tls.Config{InsecureSkipVerify: sslDisabled}
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-	}
-	return client, nil
+//GetDroplet from CF
+func (packager *CFPackager) GetDroplet(guid string) (io.ReadCloser, error) {
+	return packager.download("/v2/apps/" + guid + "/download")
+}
 
+//GetStagedDroplet downloads the current droplet of an app, as staged by CF
+func (packager *CFPackager) GetStagedDroplet(appGUID string) (io.ReadCloser, error) {
+	return packager.download("/v2/apps/" + appGUID + "/droplet/download")
 }
 
-//GetDroplet from CF
-func (packager *CFPackager) GetDroplet(guid string) ([]byte, error) {
-	token, err := packager.Cli.AccessToken()
-	if nil != err {
-		log.Fatal(err)
-	}
+//GetBuildpack downloads the bits of a buildpack from CF
+func (packager *CFPackager) GetBuildpack(guid string) (io.ReadCloser, error) {
+	return packager.download("/v2/buildpacks/" + guid + "/download")
+}
+
+func (packager *CFPackager) download(path string) (io.ReadCloser, error) {
 	api, err := packager.Cli.ApiEndpoint()
 	if nil != err {
-		log.Fatal(err)
+		return nil, err
 	}
 	client, err := packager.makeHTTPClient()
 	if nil != err {
-		log.Fatal(err)
-	}
-	url := api + "/v2/apps/" + guid + "/download"
-	req, err := http.NewRequest("GET", url, nil)
-	if nil != err {
-		log.Fatal(err)
+		return nil, err
 	}
-	req.Header.Add("Authorization", token)
-	resp, err := client.Do(req)
+	url := api + path
+	resp, err := packager.token.send(packager.Cli, client, retryPolicy, func(token string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if nil != err {
+			return nil, err
+		}
+		req.Header.Add("Authorization", token)
+		return req, nil
+	})
 	if nil != err {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
 		return nil, fmt.Errorf("Download failed. Status Code: %v. Body: %v", resp.Status, string(body))
 	}
 
-	return body, err
+	return resp.Body, nil
 }
 
 //SaveDropletToFile writes a downloaded droplet to file
-func (packager *CFPackager) SaveDropletToFile(filePath string, data []byte) error {
+func (packager *CFPackager) SaveDropletToFile(filePath string, data io.Reader) error {
 	return packager.Writer.WriteFile(filePath, data, 0644)
 }
 
@@ -142,11 +183,9 @@ func (d *CFDroplet) SaveDroplet(guid string, path string) error {
 	if nil != err {
 		return err
 	}
-	err = d.Packager.SaveDropletToFile(path, data)
-	if nil != err {
-		return err
-	}
-	return nil
+	defer data.Close()
+
+	return d.Packager.SaveDropletToFile(path, data)
 }
 
 // UploadDroplet uploads an apps droplet
@@ -156,63 +195,117 @@ func (d *CFDroplet) UploadDroplet(guid, path string) error {
 
 // UploadDroplet uploads an apps droplet
 func (packager *CFPackager) UploadDroplet(guid, path string) error {
-	token, err := packager.Cli.AccessToken()
-	if nil != err {
-		log.Fatal(err)
-	}
+	uri := fmt.Sprintf("/v2/apps/%s/bits", guid)
+	fields := [][2]string{{"resources", "[]"}, {"application", "[]"}}
+
+	return packager.upload("PUT", uri, fields, "application", path, filepath.Base(path), "app "+guid)
+}
+
+// UploadStagedDroplet uploads the bits of a droplet created with the v3 API
+func (packager *CFPackager) UploadStagedDroplet(dropletGUID, path string) error {
+	uri := fmt.Sprintf("/v3/droplets/%s/upload", dropletGUID)
+
+	return packager.upload("POST", uri, nil, "bits", path, filepath.Base(path), "droplet "+dropletGUID)
+}
+
+// UploadBuildpack uploads the bits of a buildpack under the given file name
+func (packager *CFPackager) UploadBuildpack(guid, path, filename string) error {
+	uri := fmt.Sprintf("/v2/buildpacks/%s/bits", guid)
+
+	return packager.upload("PUT", uri, nil, "buildpack", path, filename, "buildpack "+guid)
+}
+
+func (packager *CFPackager) upload(method, uri string, fields [][2]string, fileField, path, filename, target string) error {
 	api, err := packager.Cli.ApiEndpoint()
 	if nil != err {
-		log.Fatal(err)
+		return err
 	}
 	client, err := packager.makeHTTPClient()
 	if nil != err {
-		log.Fatal(err)
-	}
-
-	data, err := packager.Reader.ReadFile(path)
-	if err != nil {
 		return err
 	}
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	err = writer.WriteField("resources", "[]")
+	// The size of the body is known up front, so that it is not sent chunked
+	boundary := multipart.NewWriter(nil).Boundary()
+	envelope := &countingWriter{}
+	err = writeMultipart(envelope, boundary, fields, fileField, filename, nil)
 	if err != nil {
 		return err
 	}
-	err = writer.WriteField("application", "[]")
 
-	part, err := writer.CreateFormFile("application", filepath.Base(path))
-	if err != nil {
-		return err
-	}
-	_, err = part.Write(data)
+	resp, err := packager.token.send(packager.Cli, client, retryPolicy, func(token string) (*http.Request, error) {
+		file, size, err := packager.Reader.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		body, bodyWriter := io.Pipe()
+		go func() {
+			defer file.Close()
+			bodyWriter.CloseWithError(writeMultipart(bodyWriter, boundary, fields, fileField, filename, file))
+		}()
+
+		request, err := http.NewRequest(method, api+uri, body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		request.ContentLength = envelope.written + size
+
+		request.Header.Add("Content-Type", "multipart/form-data; boundary="+boundary)
+		request.Header.Add("Authorization", token)
+		return request, nil
+	})
 
-	err = writer.Close()
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	// v3 uploads are processed in the background and answer 202
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Received %d while uploading file for %s", resp.StatusCode, target)
+	}
 
-	uri := fmt.Sprintf("%s/v2/apps/%s/bits", api, guid)
+	return nil
+}
 
-	request, err := http.NewRequest("PUT", uri, body)
+// writeMultipart writes fields and the content of file as a multipart body
+func writeMultipart(w io.Writer, boundary string, fields [][2]string, fileField, filename string, file io.Reader) error {
+	writer := multipart.NewWriter(w)
+	err := writer.SetBoundary(boundary)
 	if err != nil {
 		return err
 	}
 
-	request.Header.Add("Content-Type", writer.FormDataContentType())
-	request.Header.Add("Authorization", token)
-
-	resp, err := client.Do(request)
+	for _, field := range fields {
+		err = writer.WriteField(field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
 
+	part, err := writer.CreateFormFile(fileField, filename)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("Received %d while uploading file for app %s", resp.StatusCode, guid)
+	if file != nil {
+		_, err = io.Copy(part, file)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return writer.Close()
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	return len(p), nil
 }
 
 func (d *CFDroplet) getGUID(appName string) (string, error) {
@@ -0,0 +1,168 @@
+package util_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func newTestPackager(t *testing.T, handler http.HandlerFunc) (*util.CFPackager, func()) {
+	server := httptest.NewServer(handler)
+
+	cli := &pluginfakes.FakeCliConnection{}
+	cli.ApiEndpointReturns(server.URL, nil)
+	cli.AccessTokenReturns("bearer token", nil)
+
+	return &util.CFPackager{
+		Cli:    cli,
+		Writer: new(util.CFFileWriter),
+		Reader: new(util.CFFileReader),
+	}, server.Close
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestCFFileWriter_LeavesNoPartialFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.zip")
+
+	err = new(util.CFFileWriter).WriteFile(path, io.MultiReader(strings.NewReader("PK"), failingReader{}), 0644)
+	if err == nil {
+		t.Fatal("expected the read error")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Fatalf("expected no file to be left, got %v", files)
+	}
+
+	if err := new(util.CFFileWriter).WriteFile(path, strings.NewReader("PK bits"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil || string(content) != "PK bits" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestCFPackager_DownloadsToFile(t *testing.T) {
+	packager, done := newTestPackager(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/apps/a1/download" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("PK droplet"))
+	})
+	defer done()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a1.zip")
+
+	if err := util.NewCFDroplet(packager.Cli, packager).SaveDroplet("a1", path); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil || string(content) != "PK droplet" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestCFPackager_UploadsMultipart(t *testing.T) {
+	packager, done := newTestPackager(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/v2/apps/a1/bits" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if len(r.TransferEncoding) != 0 {
+			t.Errorf("the body should not be chunked, got %v", r.TransferEncoding)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid multipart body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("resources") != "[]" {
+			t.Errorf("unexpected resources %q", r.FormValue("resources"))
+		}
+		file, header, err := r.FormFile("application")
+		if err != nil {
+			t.Errorf("missing application: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := ioutil.ReadAll(file)
+		if header.Filename != "a1.zip" || string(content) != "PK bits" {
+			t.Errorf("unexpected file %s: %q", header.Filename, content)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer done()
+
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a1.zip")
+	if err := ioutil.WriteFile(path, []byte("PK bits"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := packager.UploadDroplet("a1", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCFPackager_UploadsStagedDroplets(t *testing.T) {
+	packager, done := newTestPackager(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v3/droplets/d1/upload" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		file, _, err := r.FormFile("bits")
+		if err != nil {
+			t.Errorf("missing bits: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := ioutil.ReadAll(file)
+		if string(content) != "droplet" {
+			t.Errorf("unexpected droplet %q", content)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	defer done()
+
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a1.tgz")
+	if err := ioutil.WriteFile(path, []byte("droplet"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := packager.UploadStagedDroplet("d1", path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,227 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ArchiveManifestName is the name of the manifest inside a snapshot archive
+const ArchiveManifestName = "MANIFEST.json"
+
+// ArchiveFile describes one file of a snapshot archive
+type ArchiveFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ArchiveManifest lists the files of a snapshot archive
+type ArchiveManifest struct {
+	Files []ArchiveFile `json:"files"`
+}
+
+// CreateArchive writes every file below sourceDir into a gzipped tarball,
+// followed by a manifest holding their sizes and SHA-256 checksums
+func CreateArchive(archivePath, sourceDir string) error {
+	var paths []string
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	gzipWriter := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	manifest := ArchiveManifest{Files: []ArchiveFile{}}
+	for _, path := range paths {
+		name, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		file, err := addFileToArchive(tarWriter, filepath.ToSlash(name), path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name: ArchiveManifestName,
+		Mode: 0644,
+		Size: int64(len(manifestJSON)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(manifestJSON); err != nil {
+		return err
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func addFileToArchive(tarWriter *tar.Writer, name, path string) (ArchiveFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	header.Name = name
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return ArchiveFile{}, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tarWriter, hash), file)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+
+	return ArchiveFile{Path: name, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// ExtractArchive unpacks a snapshot archive into destDir and checks every
+// file against the manifest. Files the manifest does not list are rejected
+// too, as nothing vouches for them.
+func ExtractArchive(archivePath, destDir string) (*ArchiveManifest, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	gzipReader, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot archive: %s", archivePath, err)
+	}
+	defer gzipReader.Close()
+
+	var manifest *ArchiveManifest
+	extracted := make(map[string]ArchiveFile)
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		if header.Name == ArchiveManifestName {
+			manifestJSON, err := ioutil.ReadAll(tarReader)
+			if err != nil {
+				return nil, err
+			}
+			manifest = &ArchiveManifest{}
+			if err := json.Unmarshal(manifestJSON, manifest); err != nil {
+				return nil, fmt.Errorf("Invalid %s: %s", ArchiveManifestName, err)
+			}
+			continue
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return nil, fmt.Errorf("Archive entry %s is outside of the archive", header.Name)
+		}
+		file, err := extractArchiveFile(tarReader, target)
+		if err != nil {
+			return nil, err
+		}
+		file.Path = header.Name
+		extracted[header.Name] = file
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%s has no %s", archivePath, ArchiveManifestName)
+	}
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+	}
+	for path := range extracted {
+		if !listed[path] {
+			return nil, fmt.Errorf("%s is not listed in %s", path, ArchiveManifestName)
+		}
+	}
+	for _, file := range manifest.Files {
+		actual, found := extracted[file.Path]
+		if !found {
+			return nil, fmt.Errorf("%s is listed in %s but missing from the archive", file.Path, ArchiveManifestName)
+		}
+		if actual.Size != file.Size || actual.SHA256 != file.SHA256 {
+			return nil, fmt.Errorf("%s does not match its checksum in %s", file.Path, ArchiveManifestName)
+		}
+	}
+
+	return manifest, nil
+}
+
+func extractArchiveFile(reader io.Reader, target string) (ArchiveFile, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return ArchiveFile{}, err
+	}
+
+	file, err := os.Create(target)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+
+	return ArchiveFile{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, file.Close()
+}
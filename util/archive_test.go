@@ -0,0 +1,138 @@
+package util_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func TestArchive_RoundTrip(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	source := filepath.Join(tmp, "source")
+	files := map[string]string{
+		"cf-backup.json":     `{"organizations": []}`,
+		"app-bits/a1.zip":    "app bits",
+		"buildpacks/b1.zip":  "buildpack bits",
+		"app-bits/empty.zip": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(tmp, "backup.tgz")
+	if err := util.CreateArchive(archive, source); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(tmp, "dest")
+	manifest, err := util.ExtractArchive(archive, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Files) != len(files) {
+		t.Fatalf("expected %d files in the manifest, got %v", len(files), manifest.Files)
+	}
+	for _, file := range manifest.Files {
+		if file.Size != int64(len(files[file.Path])) || len(file.SHA256) != 64 {
+			t.Fatalf("unexpected manifest entry %+v", file)
+		}
+	}
+	for name, content := range files {
+		extracted, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(extracted) != content {
+			t.Fatalf("%s: expected %q, got %q", name, content, extracted)
+		}
+	}
+}
+
+func writeTestArchive(t *testing.T, path string, entries map[string]string) {
+	archive, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	gzipWriter := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range entries {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tarWriter.Close()
+	gzipWriter.Close()
+}
+
+func TestArchive_RejectsBrokenArchives(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	cases := map[string]struct {
+		entries map[string]string
+		message string
+	}{
+		"no manifest": {
+			entries: map[string]string{"cf-backup.json": "{}"},
+			message: "has no MANIFEST.json",
+		},
+		"missing file": {
+			entries: map[string]string{util.ArchiveManifestName: `{"files": [{"path": "app-bits/a1.zip", "size": 1, "sha256": "00"}]}`},
+			message: "missing from the archive",
+		},
+		"checksum mismatch": {
+			entries: map[string]string{
+				"cf-backup.json":         "{}",
+				util.ArchiveManifestName: `{"files": [{"path": "cf-backup.json", "size": 2, "sha256": "00"}]}`,
+			},
+			message: "does not match its checksum",
+		},
+		"unlisted file": {
+			entries: map[string]string{
+				"cf-backup.json":         "{}",
+				util.ArchiveManifestName: `{"files": []}`,
+			},
+			message: "cf-backup.json is not listed in MANIFEST.json",
+		},
+		"path traversal": {
+			entries: map[string]string{"../evil": "x"},
+			message: "outside of the archive",
+		},
+	}
+
+	for name, c := range cases {
+		archive := filepath.Join(tmp, strings.Replace(name, " ", "-", -1)+".tgz")
+		writeTestArchive(t, archive, c.entries)
+
+		_, err := util.ExtractArchive(archive, filepath.Join(tmp, "dest"))
+		if err == nil || !strings.Contains(err.Error(), c.message) {
+			t.Fatalf("%s: expected an error containing %q, got %v", name, c.message, err)
+		}
+	}
+}
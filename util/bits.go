@@ -0,0 +1,78 @@
+package util
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BitsUpToDate tells whether the zip at path holds the bits with the given
+// SHA-256 checksum, so that downloading them again can be skipped
+func BitsUpToDate(path, checksum string) bool {
+	if checksum == "" {
+		return false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(hash.Sum(nil)) == strings.ToLower(checksum)
+}
+
+// RemovePartialBits removes what an interrupted snapshot left in dir: the
+// temporary files of downloads of zips and droplets, and zips that were cut
+// short. It returns the names of the removed files.
+func RemovePartialBits(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+
+		switch {
+		case entry.IsDir():
+			continue
+		case strings.Contains(name, ".zip"+partialFileSuffix), strings.Contains(name, ".tgz"+partialFileSuffix):
+		case strings.HasSuffix(name, ".zip") && !completeZip(path):
+		default:
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, name)
+	}
+
+	return removed, nil
+}
+
+// completeZip tells whether the central directory at the end of a zip can
+// be read, which is not the case for a truncated one
+func completeZip(path string) bool {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	reader.Close()
+	return true
+}
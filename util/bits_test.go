@@ -0,0 +1,87 @@
+package util_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func testZip(t *testing.T) []byte {
+	buffer := &bytes.Buffer{}
+	writer := zip.NewWriter(buffer)
+	file, err := writer.Create("index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("<html></html>"))
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestBitsUpToDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := testZip(t)
+	path := filepath.Join(dir, "a1.zip")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	if !util.BitsUpToDate(path, checksum) {
+		t.Fatal("bits with a matching checksum should be up to date")
+	}
+	if util.BitsUpToDate(path, "0000") || util.BitsUpToDate(path, "") {
+		t.Fatal("bits without a matching checksum should not be up to date")
+	}
+	if util.BitsUpToDate(filepath.Join(dir, "a2.zip"), checksum) {
+		t.Fatal("missing bits should not be up to date")
+	}
+}
+
+func TestRemovePartialBits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := testZip(t)
+	files := map[string][]byte{
+		"complete.zip":          content,
+		"truncated.zip":         content[:len(content)/2],
+		"complete.zip.part1234": content[:10],
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := util.RemovePartialBits(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if len(removed) != 2 || removed[0] != "complete.zip.part1234" || removed[1] != "truncated.zip" {
+		t.Fatalf("unexpected removed files %v", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "complete.zip")); err != nil {
+		t.Fatal("the complete zip should be kept:", err)
+	}
+}
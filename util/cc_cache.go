@@ -0,0 +1,93 @@
+package util
+
+import "sync"
+
+// resourceJSONCache holds the json retrieved from the CC by url. A url
+// being retrieved is marked pending, so that concurrent readers wait for
+// it instead of retrieving it again.
+type resourceJSONCache struct {
+	lock    sync.Mutex
+	entries map[string][]byte
+	pending map[string]chan struct{}
+}
+
+func newResourceJSONCache() *resourceJSONCache {
+	return &resourceJSONCache{
+		entries: make(map[string][]byte),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+// lookup returns the cached json of url. On a miss the caller has to
+// retrieve url and call done afterwards; if another caller is already
+// retrieving it, lookup waits for that one first.
+func (cache *resourceJSONCache) lookup(url string) ([]byte, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	for {
+		if entry, hit := cache.entries[url]; hit {
+			return entry, true
+		}
+		wait, inFlight := cache.pending[url]
+		if !inFlight {
+			break
+		}
+		cache.lock.Unlock()
+		<-wait
+		cache.lock.Lock()
+	}
+
+	cache.pending[url] = make(chan struct{})
+	return nil, false
+}
+
+func (cache *resourceJSONCache) store(url string, entry []byte) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries[url] = entry
+}
+
+// done ends the retrieval of url started by a lookup miss, whether it
+// succeeded or not
+func (cache *resourceJSONCache) done(url string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if wait, inFlight := cache.pending[url]; inFlight {
+		close(wait)
+		delete(cache.pending, url)
+	}
+}
+
+// resourceModelCache holds the transformed resources by url
+type resourceModelCache struct {
+	lock    sync.Mutex
+	entries map[string]interface{}
+}
+
+func newResourceModelCache() *resourceModelCache {
+	return &resourceModelCache{entries: make(map[string]interface{})}
+}
+
+func (cache *resourceModelCache) get(url string) (interface{}, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	entry, hit := cache.entries[url]
+	return entry, hit
+}
+
+// loadOrStore returns the cached resource of url if there is one;
+// otherwise it caches resource
+func (cache *resourceModelCache) loadOrStore(url string, resource interface{}) (interface{}, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if entry, hit := cache.entries[url]; hit {
+		return entry, true
+	}
+	cache.entries[url] = resource
+	return resource, false
+}
@@ -1,10 +1,12 @@
 package util
 
 import (
-	"container/list"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
+	"sync"
 
 	"code.cloudfoundry.org/cli/plugin"
 	"github.com/SUSE/cf-plugin-backup/models"
@@ -21,6 +23,10 @@ const OrgsURL = "/v2/organizations"
 const sharedDomainsURL = "/v2/shared_domains"
 const securityGroupsURL = "/v2/security_groups"
 const featureFlagsURL = "/v2/config/feature_flags"
+const servicePlansURL = "/v2/service_plans"
+const userProvidedServiceInstancesURL = "/v2/user_provided_service_instances"
+const buildpacksURL = "/v2/buildpacks"
+const infoURL = "/v2/info"
 
 type followDecision func(childKey string) bool
 
@@ -33,107 +39,151 @@ type CliConnectionCCApi struct {
 	CliConnection plugin.CliConnection
 }
 
+// cliCommandLock serializes the cf curl calls: the cli captures the output
+// of the commands run by plugins in a single buffer
+var cliCommandLock sync.Mutex
+
 // InvokeGet invokes GET on a given path
 func (ccAPI *CliConnectionCCApi) InvokeGet(path string) (string, error) {
+	cliCommandLock.Lock()
+	defer cliCommandLock.Unlock()
+
 	output, err := ccAPI.CliConnection.CliCommandWithoutTerminalOutput("curl", path, "-X", "GET")
 
 	return strings.Join(output, ""), err
 }
 
+// ResourceError is a resource that could not be retrieved from the CC
+type ResourceError struct {
+	URL string
+	Err error
+}
+
+func (err *ResourceError) Error() string {
+	return fmt.Sprintf("%s: %s", err.URL, err.Err.Error())
+}
+
+// CrawlError is returned together with the resources that were retrieved
+// when some of their relations could not be
+type CrawlError struct {
+	Failures []*ResourceError
+}
+
+func (err *CrawlError) Error() string {
+	messages := make([]string, 0, len(err.Failures))
+	for _, failure := range err.Failures {
+		messages = append(messages, failure.Error())
+	}
+	return fmt.Sprintf("could not retrieve %d resources: %s", len(err.Failures), strings.Join(messages, "; "))
+}
+
 // CCResources represents cc resources
 type CCResources struct {
 	ccAPI cCApi
 
-	jsonRetriveCache map[string][]byte
-	transformCache   map[string]interface{}
+	jsonRetriveCache *resourceJSONCache
+	transformCache   *resourceModelCache
 
 	follow followDecision
+	// workers is the number of relations retrieved concurrently
+	workers int
+
+	failures []*ResourceError
+}
+
+var crawlWorkers = 1
+
+// SetCrawlWorkers sets the number of relations retrieved concurrently when
+// crawling the CC resources
+func SetCrawlWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	crawlWorkers = workers
 }
 
 func newCCResources(ccAPI cCApi, follow followDecision) *CCResources {
 	res := CCResources{}
 	res.ccAPI = ccAPI
 	res.follow = follow
+	res.workers = crawlWorkers
 
-	res.jsonRetriveCache = make(map[string][]byte)
-	res.transformCache = make(map[string]interface{})
+	res.jsonRetriveCache = newResourceJSONCache()
+	res.transformCache = newResourceModelCache()
 
 	return &res
 }
 
 func (ccResources *CCResources) retriveJSONGenericResource(url string) ([]byte, error) {
-	var result []byte
-
-	if cacheResult, cacheHit := ccResources.jsonRetriveCache[url]; cacheHit {
-		result = cacheResult
-	} else {
-		log.Println("Retrieving resource", url)
-
-		output, err := ccResources.ccAPI.InvokeGet(url)
-		if err != nil {
-			return nil, err
-		}
-
-		var parsedOutput map[string]interface{}
+	if cacheResult, cacheHit := ccResources.jsonRetriveCache.lookup(url); cacheHit {
+		return cacheResult, nil
+	}
+	defer ccResources.jsonRetriveCache.done(url)
 
-		err = json.Unmarshal([]byte(output), &parsedOutput)
-		if err != nil {
-			return nil, err
-		}
+	log.Println("Retrieving resource", url)
 
-		if _, isArray := parsedOutput["total_results"]; isArray {
-			nextURL := url
-			var allResources []interface{}
+	output, err := ccResources.ccAPI.InvokeGet(url)
+	if err != nil {
+		return nil, err
+	}
 
-			for nextURL != "" {
-				output, err := ccResources.ccAPI.InvokeGet(nextURL)
-				if err != nil {
-					return nil, err
-				}
+	var parsedOutput map[string]interface{}
 
-				var collection map[string]interface{}
-				err = json.Unmarshal([]byte(output), &collection)
-				if err != nil {
-					return nil, err
-				}
+	err = json.Unmarshal([]byte(output), &parsedOutput)
+	if err != nil {
+		return nil, err
+	}
 
-				allResources = append(allResources, collection["resources"].([]interface{})...)
+	if _, isArray := parsedOutput["total_results"]; isArray {
+		nextURL := url
+		var allResources []interface{}
 
-				if collection["next_url"] != nil {
-					nextURL = collection["next_url"].(string)
-				} else {
-					nextURL = ""
-				}
+		for nextURL != "" {
+			output, err := ccResources.ccAPI.InvokeGet(nextURL)
+			if err != nil {
+				return nil, err
 			}
 
-			parsedOutput["next_url"] = nil
-			parsedOutput["prev_url"] = nil
-			parsedOutput["total_pages"] = 1
-			parsedOutput["total_results"] = len(allResources)
-			parsedOutput["resources"] = allResources
+			var collection map[string]interface{}
+			err = json.Unmarshal([]byte(output), &collection)
+			if err != nil {
+				return nil, err
+			}
 
-			for _, element := range allResources {
-				metadata := element.(map[string]interface{})["metadata"]
-				elementURL := metadata.(map[string]interface{})["url"].(string)
-				jsonElement, err := json.Marshal(element)
-				if err != nil {
-					return nil, err
-				}
+			allResources = append(allResources, collection["resources"].([]interface{})...)
 
-				ccResources.jsonRetriveCache[elementURL] = jsonElement
+			if collection["next_url"] != nil {
+				nextURL = collection["next_url"].(string)
+			} else {
+				nextURL = ""
 			}
 		}
 
-		jsonOutput, err := json.Marshal(parsedOutput)
-		if err != nil {
-			return nil, err
+		parsedOutput["next_url"] = nil
+		parsedOutput["prev_url"] = nil
+		parsedOutput["total_pages"] = 1
+		parsedOutput["total_results"] = len(allResources)
+		parsedOutput["resources"] = allResources
+
+		for _, element := range allResources {
+			metadata := element.(map[string]interface{})["metadata"]
+			elementURL := metadata.(map[string]interface{})["url"].(string)
+			jsonElement, err := json.Marshal(element)
+			if err != nil {
+				return nil, err
+			}
+
+			ccResources.jsonRetriveCache.store(elementURL, jsonElement)
 		}
+	}
 
-		ccResources.jsonRetriveCache[url] = jsonOutput
-		result = jsonOutput
+	jsonOutput, err := json.Marshal(parsedOutput)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	ccResources.jsonRetriveCache.store(url, jsonOutput)
+	return jsonOutput, nil
 }
 
 func (ccResources *CCResources) retriveParsedGenericResource(url string) (interface{}, error) {
@@ -178,73 +228,146 @@ func (ccResources *CCResources) retriveFeatureFlagsResource(url string) ([]*mode
 	return collection, err
 }
 
-type retriveQueueItem struct {
-	resourceTarget interface{}
-	depth          int
-}
+// relationTask is a relation of a resource to retrieve
+type relationTask struct {
+	resource *models.ResourceModel
+	childKey string
+	url      string
 
-func (ccResources *CCResources) getGenericResource(startURL string, relationsDepth int) interface{} {
-	startResource, err := ccResources.retriveParsedGenericResource(startURL)
-	FreakOut(err)
+	result interface{}
+	err    error
+}
 
-	queue := list.New()
-	queue.PushBack(retriveQueueItem{resourceTarget: startResource, depth: 0})
+// relationTasks lists the relations to follow of the resources in a level.
+// Entity keys are sorted so that the order does not depend on map iteration.
+func (ccResources *CCResources) relationTasks(level []interface{}) []*relationTask {
+	var tasks []*relationTask
 
-	for {
-		e := queue.Front()
-		if e == nil {
-			break
+	for _, target := range level {
+		var resources []*models.ResourceModel
+		switch value := target.(type) {
+		case *[]*models.ResourceModel:
+			resources = *value
+		case *models.ResourceModel:
+			resources = []*models.ResourceModel{value}
 		}
 
-		queueElement := e.Value.(retriveQueueItem)
-		currentDepth := queueElement.depth
+		for _, resource := range resources {
+			if resource == nil {
+				continue
+			}
 
-		if currentDepth < relationsDepth {
+			entityKeys := make([]string, 0, len(resource.Entity))
+			for entityKey := range resource.Entity {
+				entityKeys = append(entityKeys, entityKey)
+			}
+			sort.Strings(entityKeys)
 
-			if listOfResources, isArray := queueElement.resourceTarget.(*[]*models.ResourceModel); isArray {
-				for _, resource := range *listOfResources {
-					queue.PushBack(retriveQueueItem{resourceTarget: resource, depth: currentDepth})
+			for _, entityKey := range entityKeys {
+				if !strings.HasSuffix(entityKey, urlSuffix) {
+					continue
 				}
-			} else {
-				resource := queueElement.resourceTarget.(*models.ResourceModel)
+				childKey := strings.TrimSuffix(entityKey, urlSuffix)
+				if !(ccResources.follow == nil || ccResources.follow(childKey)) {
+					continue
+				}
+				childURL, isURL := resource.Entity[entityKey].(string)
+				if !isURL {
+					continue
+				}
+				tasks = append(tasks, &relationTask{resource: resource, childKey: childKey, url: childURL})
+			}
+		}
+	}
 
-				for entityKey, entityValue := range resource.Entity {
-					if strings.HasSuffix(entityKey, urlSuffix) {
-						childEntity := strings.TrimSuffix(entityKey, urlSuffix)
+	return tasks
+}
 
-						if ccResources.follow == nil || ccResources.follow(childEntity) {
-							childURL := entityValue.(string)
-							childResource, err := ccResources.retriveParsedGenericResource(childURL)
-							FreakOut(err)
+// retrieveRelations retrieves the relations of tasks using the configured
+// number of workers
+func (ccResources *CCResources) retrieveRelations(tasks []*relationTask) {
+	runWorkers(ccResources.workers, len(tasks), func(i int) {
+		task := tasks[i]
+		task.result, task.err = ccResources.retriveParsedGenericResource(task.url)
+	})
+}
 
-							resource.Entity[childEntity] = childResource
-							queue.PushBack(retriveQueueItem{resourceTarget: childResource, depth: currentDepth + 1})
-						}
-					}
-				}
+// runWorkers calls work for each index below count on workers concurrent
+// workers
+func runWorkers(workers, count int, work func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
 
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				work(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// getGenericResource retrieves startURL and follows its relations. Failing
+// to retrieve startURL is an error; relations that fail are left out and
+// reported by a CrawlError returned together with the resource.
+func (ccResources *CCResources) getGenericResource(startURL string, relationsDepth int) (interface{}, error) {
+	startResource, err := ccResources.retriveParsedGenericResource(startURL)
+	if err != nil {
+		return nil, &ResourceError{URL: startURL, Err: err}
+	}
+	ccResources.failures = nil
+
+	// relations are retrieved one level at a time; the results are linked
+	// in task order, so the outcome is the same whatever the worker count
+	level := []interface{}{startResource}
+	for depth := 0; depth < relationsDepth && len(level) > 0; depth++ {
+		tasks := ccResources.relationTasks(level)
+		ccResources.retrieveRelations(tasks)
+
+		level = nil
+		for _, task := range tasks {
+			if task.err != nil {
+				log.Printf("Could not retrieve resource %s: %v", task.url, task.err)
+				ccResources.failures = append(ccResources.failures, &ResourceError{URL: task.url, Err: task.err})
+				continue
 			}
-		}
 
-		queue.Remove(e)
+			task.resource.Entity[task.childKey] = task.result
+			level = append(level, task.result)
+		}
 	}
 
-	return startResource
+	if len(ccResources.failures) > 0 {
+		return startResource, &CrawlError{Failures: ccResources.failures}
+	}
+	return startResource, nil
 }
 
 // GetResource gets a resource
-func (ccResources *CCResources) GetResource(url string, relationsDepth int) *models.ResourceModel {
-	res := ccResources.getGenericResource(url, relationsDepth)
-	return res.(*models.ResourceModel)
+func (ccResources *CCResources) GetResource(url string, relationsDepth int) (*models.ResourceModel, error) {
+	res, err := ccResources.getGenericResource(url, relationsDepth)
+	model, _ := res.(*models.ResourceModel)
+	return model, err
 }
 
 // GetResources gets resources
-func (ccResources *CCResources) GetResources(url string, relationsDepth int) []*models.ResourceModel {
-	res := ccResources.getGenericResource(url, relationsDepth)
+func (ccResources *CCResources) GetResources(url string, relationsDepth int) ([]*models.ResourceModel, error) {
+	res, err := ccResources.getGenericResource(url, relationsDepth)
 	if model, ok := res.(*[]*models.ResourceModel); ok {
-		return *model
+		return *model, err
 	}
-	return nil
+	return nil, err
 }
 
 func (ccResources *CCResources) recreateLinkForEntity(resource *models.ResourceModel) {
@@ -270,18 +393,16 @@ func (ccResources *CCResources) recreateLinkForEntity(resource *models.ResourceM
 
 				resource.Entity[childKey] = childResource
 
-				if cacheEntry, hit := ccResources.transformCache[childURL]; hit {
+				if cacheEntry, hit := ccResources.transformCache.loadOrStore(childURL, childResource); hit {
 					if resourceCacheEntry, isSingleResource := cacheEntry.(*models.ResourceModel); isSingleResource {
 						if resourceCacheEntry.Entity == nil {
 							child := childResource.(*models.ResourceModel)
 							resourceCacheEntry.Entity = child.Entity
 						}
 					}
-				} else {
-					ccResources.transformCache[childURL] = childResource
 				}
 			} else {
-				if cacheEntry, hit := ccResources.transformCache[childURL]; hit {
+				if cacheEntry, hit := ccResources.transformCache.get(childURL); hit {
 					resource.Entity[childKey] = cacheEntry
 				}
 			}
@@ -289,6 +410,8 @@ func (ccResources *CCResources) recreateLinkForEntity(resource *models.ResourceM
 	}
 }
 
+// transformToResourceModelGeneric transforms raw relations; values of any
+// other type are kept as they are and rejected later by the typed models
 func (ccResources *CCResources) transformToResourceModelGeneric(r interface{}) interface{} {
 	switch r.(type) {
 	case map[string]interface{}:
@@ -296,8 +419,7 @@ func (ccResources *CCResources) transformToResourceModelGeneric(r interface{}) i
 	case []interface{}:
 		return ccResources.TransformToResourceModels(r)
 	}
-	log.Fatalf("unknown resource type %T", r)
-	return nil
+	return r
 }
 
 func (ccResources *CCResources) transformToResourceModel(resource interface{}) *models.ResourceModel {
@@ -316,7 +438,7 @@ func (ccResources *CCResources) transformToResourceModel(resource interface{}) *
 	// Cache handling
 
 	resourceURL := resourceModel.Metadata["url"].(string)
-	if cacheEntry, hit := ccResources.transformCache[resourceURL]; hit {
+	if cacheEntry, hit := ccResources.transformCache.loadOrStore(resourceURL, &resourceModel); hit {
 		cacheEntryValue := cacheEntry.(*models.ResourceModel)
 
 		cacheEntryHasEntity := cacheEntryValue.Entity != nil
@@ -326,8 +448,6 @@ func (ccResources *CCResources) transformToResourceModel(resource interface{}) *
 		if !hasEntity && cacheEntryHasEntity {
 			resourceModel.Entity = cacheEntryValue.Entity
 		}
-	} else {
-		ccResources.transformCache[resourceURL] = &resourceModel
 	}
 
 	if hasEntity {
@@ -422,6 +542,10 @@ func CreateOrgCCResources(ccAPI cCApi) *CCResources {
 		"private_domains",
 
 		"stack",
+
+		"service_instances",
+		"service_bindings",
+		"service_keys",
 	}
 	resourceURLsWhitelist := mapset.NewSetFromSlice(resourceURLsWhitelistSlice)
 
@@ -435,16 +559,14 @@ func CreateOrgCCResources(ccAPI cCApi) *CCResources {
 }
 
 // GetResources retrieves resources for a given url
-func GetResources(cliConnection plugin.CliConnection, url string, relationsDepth int) []*models.ResourceModel {
+func GetResources(ccAPI cCApi, url string, relationsDepth int) ([]*models.ResourceModel, error) {
 	follow := func(childKey string) bool {
 		return false
 	}
 
-	ccResources := newCCResources(&CliConnectionCCApi{CliConnection: cliConnection}, follow)
-
-	resources := ccResources.GetResources(url, relationsDepth)
+	ccResources := newCCResources(ccAPI, follow)
 
-	return resources
+	return ccResources.GetResources(url, relationsDepth)
 }
 
 // CreateFeatureFlagsCCResources creates feature flags resources
@@ -461,9 +583,7 @@ func CreateFeatureFlagsCCResources(ccAPI cCApi) *CCResources {
 // GetOrgsResourcesRecurively returns all orgs
 func GetOrgsResourcesRecurively(ccAPI cCApi) ([]*models.ResourceModel, error) {
 	ccResources := CreateOrgCCResources(ccAPI)
-	resources := ccResources.GetResources(OrgsURL, 5)
-
-	return resources, nil
+	return ccResources.GetResources(OrgsURL, 5)
 }
 
 // CreateSharedDomainsCCResources creates shared domains resources
@@ -494,6 +614,39 @@ func CreateSecurityGroupsCCResources(ccAPI cCApi) *CCResources {
 	return ccResources
 }
 
+// CreateServicePlansCCResources creates service plans resources
+func CreateServicePlansCCResources(ccAPI cCApi) *CCResources {
+	follow := func(childKey string) bool {
+		return childKey == "service"
+	}
+
+	ccResources := newCCResources(ccAPI, follow)
+
+	return ccResources
+}
+
+// CreateUserProvidedServiceInstancesCCResources creates user provided service instances resources
+func CreateUserProvidedServiceInstancesCCResources(ccAPI cCApi) *CCResources {
+	follow := func(childKey string) bool {
+		return false
+	}
+
+	ccResources := newCCResources(ccAPI, follow)
+
+	return ccResources
+}
+
+// CreateBuildpacksCCResources creates buildpacks resources
+func CreateBuildpacksCCResources(ccAPI cCApi) *CCResources {
+	follow := func(childKey string) bool {
+		return false
+	}
+
+	ccResources := newCCResources(ccAPI, follow)
+
+	return ccResources
+}
+
 // RestoreQuotaResourceModels gets quotas as resource models
 func RestoreQuotaResourceModels(quotaResources interface{}) *[]*models.ResourceModel {
 	ccResources := CreateQuotaCCResources(nil)
@@ -518,6 +671,72 @@ func RestoreOrgResourceModels(orgResources interface{}) *[]*models.ResourceModel
 	return transformedRes
 }
 
+// RestoreServicePlanResourceModels gets service plans as resource models
+func RestoreServicePlanResourceModels(servicePlanResources interface{}) *[]*models.ResourceModel {
+	ccResources := CreateServicePlansCCResources(nil)
+	transformedRes := ccResources.TransformToResourceModels(servicePlanResources)
+
+	return transformedRes
+}
+
+// RestoreUserProvidedServiceInstanceResourceModels gets user provided service instances as resource models
+func RestoreUserProvidedServiceInstanceResourceModels(instanceResources interface{}) *[]*models.ResourceModel {
+	ccResources := CreateUserProvidedServiceInstancesCCResources(nil)
+	transformedRes := ccResources.TransformToResourceModels(instanceResources)
+
+	return transformedRes
+}
+
+// RestoreBuildpackResourceModels gets buildpacks as resource models
+func RestoreBuildpackResourceModels(buildpackResources interface{}) *[]*models.ResourceModel {
+	ccResources := CreateBuildpacksCCResources(nil)
+	transformedRes := ccResources.TransformToResourceModels(buildpackResources)
+
+	return transformedRes
+}
+
+// RestoreOrganizations gets orgs as typed models. Like NewOrganizations,
+// it returns a *models.SkippedError with the orgs when apps or routes were
+// left out.
+func RestoreOrganizations(orgResources interface{}) ([]models.Organization, error) {
+	if orgResources == nil {
+		return nil, nil
+	}
+	return models.NewOrganizations(*RestoreOrgResourceModels(orgResources))
+}
+
+// RestoreQuotas gets org quota definitions as typed models
+func RestoreQuotas(quotaResources interface{}) ([]models.Quota, error) {
+	if quotaResources == nil {
+		return nil, nil
+	}
+	return models.NewQuotas("org_quota_definitions", *RestoreQuotaResourceModels(quotaResources))
+}
+
+// RestoreSpaceQuotas gets space quota definitions as typed models
+func RestoreSpaceQuotas(spaceQuotaResources interface{}) ([]models.Quota, error) {
+	if spaceQuotaResources == nil {
+		return nil, nil
+	}
+	return models.NewQuotas("space_quota_definitions", *RestoreSpaceQuotaResourceModels(spaceQuotaResources))
+}
+
+// RestoreSharedDomains gets shared domains as typed models
+func RestoreSharedDomains(domainResources interface{}) ([]models.Domain, error) {
+	if domainResources == nil {
+		return nil, nil
+	}
+	return models.NewDomains(*CreateSharedDomainsCCResources(nil).TransformToResourceModels(domainResources))
+}
+
+// RestoreSecurityGroups gets security groups as typed models
+func RestoreSecurityGroups(groupResources interface{}) ([]models.SecurityGroup, error) {
+	if groupResources == nil {
+		return nil, nil
+	}
+	return models.NewSecurityGroups(*CreateSecurityGroupsCCResources(nil).TransformToResourceModels(groupResources))
+}
+
 // RestoreFlagsResourceModels gets flags as resource models
 func RestoreFlagsResourceModels(flagResources interface{}) *[]*models.FeatureFlagModel {
 	ccResources := CreateFeatureFlagsCCResources(nil)
@@ -530,18 +749,18 @@ func RestoreFlagsResourceModels(flagResources interface{}) *[]*models.FeatureFla
 func GetSharedDomains(ccAPI cCApi) (interface{}, error) {
 	ccResources := CreateSharedDomainsCCResources(ccAPI)
 
-	resources := ccResources.GetResources(sharedDomainsURL, 1)
+	resources, err := ccResources.GetResources(sharedDomainsURL, 1)
 
-	return resources, nil
+	return resources, err
 }
 
 // GetOrgQuotaDefinitions returns the Organization Quota definitions
 func GetOrgQuotaDefinitions(ccAPI cCApi) (interface{}, error) {
 	ccResources := CreateSharedDomainsCCResources(ccAPI)
 
-	resources := ccResources.GetResources(orgQuotasURL, 2)
+	resources, err := ccResources.GetResources(orgQuotasURL, 2)
 
-	return resources, nil
+	return resources, err
 
 }
 
@@ -549,9 +768,9 @@ func GetOrgQuotaDefinitions(ccAPI cCApi) (interface{}, error) {
 func GetSpaceQuotaDefinitions(ccAPI cCApi) (interface{}, error) {
 	ccResources := CreateSharedDomainsCCResources(ccAPI)
 
-	resources := ccResources.GetResources(spaceQuotasURL, 2)
+	resources, err := ccResources.GetResources(spaceQuotasURL, 2)
 
-	return resources, nil
+	return resources, err
 
 }
 
@@ -573,13 +792,204 @@ func GetFeatureFlags(ccAPI cCApi) (*[]*models.FeatureFlagModel, error) {
 func GetSecurityGroups(ccAPI cCApi) (interface{}, error) {
 	ccResources := CreateSecurityGroupsCCResources(ccAPI)
 
-	resources := ccResources.GetResources(securityGroupsURL, 2)
+	resources, err := ccResources.GetResources(securityGroupsURL, 2)
+
+	return resources, err
+}
+
+// GetServicePlans returns service plans together with their services
+func GetServicePlans(ccAPI cCApi) (interface{}, error) {
+	ccResources := CreateServicePlansCCResources(ccAPI)
+
+	resources, err := ccResources.GetResources(servicePlansURL, 1)
+
+	return resources, err
+}
+
+// GetUserProvidedServiceInstances returns user provided service instances
+func GetUserProvidedServiceInstances(ccAPI cCApi) (interface{}, error) {
+	ccResources := CreateUserProvidedServiceInstancesCCResources(ccAPI)
+
+	resources, err := ccResources.GetResources(userProvidedServiceInstancesURL, 1)
+
+	return resources, err
+}
+
+// AddServiceInstanceParameters stores the parameters of every managed service
+// instance in the org tree, for the instances whose broker allows reading them
+func AddServiceInstanceParameters(ccAPI cCApi, orgs []*models.ResourceModel) {
+	for _, org := range orgs {
+		spaces, ok := org.Entity["spaces"].(*[]*models.ResourceModel)
+		if !ok {
+			continue
+		}
+		for _, space := range *spaces {
+			instances, ok := space.Entity["service_instances"].(*[]*models.ResourceModel)
+			if !ok {
+				continue
+			}
+			for _, instance := range *instances {
+				url := instance.Metadata["url"].(string) + "/parameters"
+				log.Println("Retrieving resource", url)
+
+				output, err := ccAPI.InvokeGet(url)
+				if err != nil {
+					continue
+				}
 
-	return resources, nil
+				var parameters map[string]interface{}
+				if json.Unmarshal([]byte(output), &parameters) != nil || parameters["error_code"] != nil {
+					continue
+				}
+				instance.Entity["parameters"] = parameters
+			}
+		}
+	}
 }
 
-// CreateBackupJSON creates backup json
+// GetAppPackageHashes returns the SHA-256 checksum of the newest ready
+// package of every app in the org tree, by app guid. Docker apps, apps
+// whose packages can not be read and packages with another checksum type,
+// such as the sha1 of older CCs, are left out, so their bits are downloaded.
+func GetAppPackageHashes(ccAPI cCApi, orgs []*models.ResourceModel) map[string]string {
+	appGUIDs := bitsAppGUIDs(orgs)
+	found := make([]string, len(appGUIDs))
+
+	runWorkers(crawlWorkers, len(appGUIDs), func(i int) {
+		url := "/v3/apps/" + appGUIDs[i] + "/packages?states=READY&types=bits&order_by=-created_at&per_page=1"
+		log.Println("Retrieving resource", url)
+
+		output, err := ccAPI.InvokeGet(url)
+		if err != nil {
+			return
+		}
+
+		var packages struct {
+			Resources []struct {
+				Data struct {
+					Checksum struct {
+						Type  string `json:"type"`
+						Value string `json:"value"`
+					} `json:"checksum"`
+				} `json:"data"`
+			} `json:"resources"`
+		}
+		if json.Unmarshal([]byte(output), &packages) != nil || len(packages.Resources) == 0 {
+			return
+		}
+		if checksum := packages.Resources[0].Data.Checksum; checksum.Type == "sha256" {
+			found[i] = checksum.Value
+		}
+	})
+
+	hashes := make(map[string]string)
+	for i, hash := range found {
+		if hash != "" {
+			hashes[appGUIDs[i]] = hash
+		}
+	}
+	return hashes
+}
+
+// GetAppDroplets returns the current droplet of every app in the org tree,
+// by app guid. Docker apps and apps without a staged droplet are left out.
+func GetAppDroplets(ccAPI cCApi, orgs []*models.ResourceModel) map[string]models.Droplet {
+	appGUIDs := bitsAppGUIDs(orgs)
+	found := make([]*models.Droplet, len(appGUIDs))
+
+	runWorkers(crawlWorkers, len(appGUIDs), func(i int) {
+		url := "/v3/apps/" + appGUIDs[i] + "/droplets/current"
+		log.Println("Retrieving resource", url)
+
+		output, err := ccAPI.InvokeGet(url)
+		if err != nil {
+			return
+		}
+
+		var droplet struct {
+			GUID     string `json:"guid"`
+			State    string `json:"state"`
+			Checksum struct {
+				Type  string `json:"type"`
+				Value string `json:"value"`
+			} `json:"checksum"`
+			ProcessTypes map[string]string `json:"process_types"`
+		}
+		if json.Unmarshal([]byte(output), &droplet) != nil || droplet.GUID == "" || droplet.State != "STAGED" {
+			return
+		}
+		saved := &models.Droplet{GUID: droplet.GUID, ProcessTypes: droplet.ProcessTypes}
+		if droplet.Checksum.Type == "sha256" {
+			saved.Checksum = droplet.Checksum.Value
+		}
+		found[i] = saved
+	})
+
+	droplets := make(map[string]models.Droplet)
+	for i, droplet := range found {
+		if droplet != nil {
+			droplets[appGUIDs[i]] = *droplet
+		}
+	}
+	return droplets
+}
+
+// bitsAppGUIDs returns the guids of the apps of the org tree that are not
+// docker apps, and so have bits
+func bitsAppGUIDs(orgs []*models.ResourceModel) []string {
+	var guids []string
+
+	for _, org := range orgs {
+		spaces, ok := org.Entity["spaces"].(*[]*models.ResourceModel)
+		if !ok {
+			continue
+		}
+		for _, space := range *spaces {
+			apps, ok := space.Entity["apps"].(*[]*models.ResourceModel)
+			if !ok {
+				continue
+			}
+			for _, app := range *apps {
+				if app.Entity["docker_image"] == nil {
+					guids = append(guids, app.Metadata["guid"].(string))
+				}
+			}
+		}
+	}
+
+	return guids
+}
+
+// GetBuildpacks returns buildpacks
+func GetBuildpacks(ccAPI cCApi) (interface{}, error) {
+	ccResources := CreateBuildpacksCCResources(ccAPI)
+
+	resources, err := ccResources.GetResources(buildpacksURL, 1)
+
+	return resources, err
+}
+
+// GetAPIVersion returns the CC API version from /v2/info
+func GetAPIVersion(ccAPI cCApi) (string, error) {
+	output, err := ccAPI.InvokeGet(infoURL)
+	if err != nil {
+		return "", err
+	}
+
+	var info struct {
+		APIVersion string `json:"api_version"`
+	}
+	if err := json.Unmarshal([]byte(output), &info); err != nil {
+		return "", err
+	}
+
+	return info.APIVersion, nil
+}
+
+// CreateBackupJSON creates backup json in the current format version
 func CreateBackupJSON(backupModel models.BackupModel) (string, error) {
+	backupModel.FormatVersion = BackupFormatVersion
+
 	jsonResources, err := json.MarshalIndent(backupModel, "", " ")
 	if err != nil {
 		return "", err
@@ -588,10 +998,15 @@ func CreateBackupJSON(backupModel models.BackupModel) (string, error) {
 	return string(jsonResources), nil
 }
 
-// ReadBackupJSON reads backup json
+// ReadBackupJSON reads backup json, migrating older format versions
 func ReadBackupJSON(jsonBytes []byte) (*models.BackupModel, error) {
+	migrated, err := migrateBackupJSON(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+
 	backupModel := models.BackupModel{}
-	err := json.Unmarshal([]byte(jsonBytes), &backupModel)
+	err = json.Unmarshal(migrated, &backupModel)
 	if err != nil {
 		return nil, err
 	}
@@ -165,7 +165,10 @@ func TestGetResources_RecurseWithLoops(t *testing.T) {
 	ccApi := CCApiMock{Responses: fakeRecursiveResponses}
 
 	ccResources := util.CreateOrgCCResources(&ccApi)
-	result := ccResources.GetResources(util.OrgsURL, 10)
+	result, err := ccResources.GetResources(util.OrgsURL, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if len(result) != 1 {
 		t.Fatal("result is not of length 1")
@@ -194,6 +197,45 @@ func TestGetResources_RecurseWithLoops(t *testing.T) {
 	}
 }
 
+func TestGetResources_FailedRelationsAreReported(t *testing.T) {
+	fakeResponses := map[string]string{}
+	for url, response := range fakeRecursiveResponses {
+		fakeResponses[url] = response
+	}
+	spacesURL := "/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/spaces"
+	delete(fakeResponses, spacesURL)
+	ccApi := CCApiMock{Responses: fakeResponses}
+
+	result, err := util.GetOrgsResourcesRecurively(&ccApi)
+
+	crawlErr, ok := err.(*util.CrawlError)
+	if !ok {
+		t.Fatalf("expected a crawl error, got %v", err)
+	}
+	if len(crawlErr.Failures) != 1 || crawlErr.Failures[0].URL != spacesURL {
+		t.Fatalf("unexpected failures %v", crawlErr.Failures)
+	}
+	if len(result) != 1 || result[0].Entity["name"] != "o1" {
+		t.Fatal("the org should still be retrieved")
+	}
+	if _, found := result[0].Entity["spaces"]; found {
+		t.Fatal("the failed relation should be left out")
+	}
+}
+
+func TestGetResources_FailedStartIsAnError(t *testing.T) {
+	ccApi := CCApiMock{Responses: map[string]string{}}
+
+	result, err := util.GetOrgsResourcesRecurively(&ccApi)
+
+	if _, ok := err.(*util.ResourceError); !ok {
+		t.Fatalf("expected a resource error, got %v", err)
+	}
+	if result != nil {
+		t.Fatal("expected no result, got", result)
+	}
+}
+
 func TestGetResources_StacksArePulled(t *testing.T) {
 	fakeResponses := map[string]string{}
 	err := json.Unmarshal([]byte(ccRecording1), &fakeResponses)
@@ -239,3 +281,316 @@ func TestGetResources_StacksArePulled(t *testing.T) {
 		}
 	}
 }
+
+var fakeServiceResponses map[string]string = map[string]string{
+	"/v2/organizations": `
+{
+   "total_results": 1,
+   "total_pages": 1,
+   "prev_url": null,
+   "next_url": null,
+   "resources": [
+      {
+         "metadata": {
+            "guid": "91656f3b-0e8d-4cea-9555-4460d309937a",
+            "url": "/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a"
+         },
+         "entity": {
+            "name": "o1",
+            "spaces_url": "/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/spaces"
+         }
+      }
+   ]
+}
+`,
+
+	"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/spaces": `
+{
+   "total_results": 1,
+   "total_pages": 1,
+   "prev_url": null,
+   "next_url": null,
+   "resources": [
+      {
+         "metadata": {
+            "guid": "fac8c0f5-0e48-4a1c-a8ef-13aae586a650",
+            "url": "/v2/spaces/fac8c0f5-0e48-4a1c-a8ef-13aae586a650"
+         },
+         "entity": {
+            "name": "s1",
+            "organization_guid": "91656f3b-0e8d-4cea-9555-4460d309937a",
+            "service_instances_url": "/v2/spaces/fac8c0f5-0e48-4a1c-a8ef-13aae586a650/service_instances"
+         }
+      }
+   ]
+}
+`,
+
+	"/v2/spaces/fac8c0f5-0e48-4a1c-a8ef-13aae586a650/service_instances": `
+{
+   "total_results": 1,
+   "total_pages": 1,
+   "prev_url": null,
+   "next_url": null,
+   "resources": [
+      {
+         "metadata": {
+            "guid": "5fbb0e5c-3a3e-4d0a-8b1c-5cd3f2a1b7e1",
+            "url": "/v2/service_instances/5fbb0e5c-3a3e-4d0a-8b1c-5cd3f2a1b7e1"
+         },
+         "entity": {
+            "name": "db",
+            "service_plan_guid": "0b0a4c6a-6f4f-4e40-9e0e-2a1bdf1e0c2a",
+            "space_guid": "fac8c0f5-0e48-4a1c-a8ef-13aae586a650",
+            "tags": ["sql"],
+            "service_keys_url": "/v2/service_instances/5fbb0e5c-3a3e-4d0a-8b1c-5cd3f2a1b7e1/service_keys"
+         }
+      }
+   ]
+}
+`,
+
+	"/v2/service_instances/5fbb0e5c-3a3e-4d0a-8b1c-5cd3f2a1b7e1/service_keys": `
+{
+   "total_results": 1,
+   "total_pages": 1,
+   "prev_url": null,
+   "next_url": null,
+   "resources": [
+      {
+         "metadata": {
+            "guid": "d1b9a1e8-2f0e-4c3f-9d1a-7d6f0a9c8e11",
+            "url": "/v2/service_keys/d1b9a1e8-2f0e-4c3f-9d1a-7d6f0a9c8e11"
+         },
+         "entity": {
+            "name": "k1",
+            "service_instance_guid": "5fbb0e5c-3a3e-4d0a-8b1c-5cd3f2a1b7e1"
+         }
+      }
+   ]
+}
+`,
+
+	"/v2/service_instances/5fbb0e5c-3a3e-4d0a-8b1c-5cd3f2a1b7e1/parameters": `
+{
+   "size": "large"
+}
+`,
+}
+
+func TestGetResources_ParallelCrawlIsDeterministic(t *testing.T) {
+	fakeResponses := map[string]string{}
+	err := json.Unmarshal([]byte(ccRecording1), &fakeResponses)
+	if err != nil {
+		t.Fatal("json.Unmarshal failed", err)
+	}
+	ccApi := CCApiMock{Responses: fakeResponses}
+	defer util.SetCrawlWorkers(1)
+
+	crawl := func(workers int) string {
+		util.SetCrawlWorkers(workers)
+		result, err := util.GetOrgsResourcesRecurively(&ccApi)
+		if err != nil {
+			t.Fatal("GetOrgsResourcesRecurively failed", err)
+		}
+		resultJSON, err := json.Marshal(result)
+		if err != nil {
+			t.Fatal("json.Marshal failed", err)
+		}
+		return string(resultJSON)
+	}
+
+	sequential := crawl(1)
+	for i := 0; i < 5; i++ {
+		if parallel := crawl(8); parallel != sequential {
+			t.Fatalf("parallel crawl differs from the sequential one:\n%s\n%s", parallel, sequential)
+		}
+	}
+}
+
+func TestGetResources_ServiceInstancesArePulled(t *testing.T) {
+	ccApi := CCApiMock{Responses: fakeServiceResponses}
+
+	result, err := util.GetOrgsResourcesRecurively(&ccApi)
+	if err != nil {
+		t.Fatal("GetOrgsResourcesRecurively failed", err)
+	}
+	util.AddServiceInstanceParameters(&ccApi, result)
+
+	spaces := *(result[0].Entity["spaces"]).(*[]*models.ResourceModel)
+	instances, ok := spaces[0].Entity["service_instances"].(*[]*models.ResourceModel)
+	if !ok || len(*instances) != 1 {
+		t.Fatal("service instances are missing")
+	}
+
+	instance := (*instances)[0]
+	if instance.Entity["name"] != "db" {
+		t.Fatal("invalid service instance name")
+	}
+
+	keys, ok := instance.Entity["service_keys"].(*[]*models.ResourceModel)
+	if !ok || len(*keys) != 1 || (*keys)[0].Entity["name"] != "k1" {
+		t.Fatal("service keys are missing")
+	}
+
+	parameters, ok := instance.Entity["parameters"].(map[string]interface{})
+	if !ok || parameters["size"] != "large" {
+		t.Fatal("service instance parameters are missing", instance.Entity["parameters"])
+	}
+}
+
+func TestGetAppPackageHashes(t *testing.T) {
+	fakeResponses := map[string]string{
+		"/v3/apps/a1/packages?states=READY&types=bits&order_by=-created_at&per_page=1": `
+{
+   "pagination": {"total_results": 1},
+   "resources": [
+      {"guid": "p1", "type": "bits", "data": {"checksum": {"type": "sha256", "value": "abc123"}}}
+   ]
+}
+`,
+		"/v3/apps/a2/packages?states=READY&types=bits&order_by=-created_at&per_page=1": `
+{
+   "pagination": {"total_results": 0},
+   "resources": []
+}
+`,
+		"/v3/apps/a4/packages?states=READY&types=bits&order_by=-created_at&per_page=1": `
+{
+   "pagination": {"total_results": 1},
+   "resources": [
+      {"guid": "p4", "type": "bits", "data": {"checksum": {"type": "sha1", "value": "def456"}}}
+   ]
+}
+`,
+	}
+	ccApi := CCApiMock{Responses: fakeResponses}
+
+	app := func(guid string, dockerImage interface{}) *models.ResourceModel {
+		return &models.ResourceModel{
+			Metadata: map[string]interface{}{"guid": guid},
+			Entity:   map[string]interface{}{"docker_image": dockerImage},
+		}
+	}
+	apps := []*models.ResourceModel{app("a1", nil), app("a2", nil), app("a3", "busybox"), app("a4", nil)}
+	spaces := []*models.ResourceModel{{Entity: map[string]interface{}{"apps": &apps}}}
+	orgs := []*models.ResourceModel{{Entity: map[string]interface{}{"spaces": &spaces}}}
+	util.SetCrawlWorkers(4)
+	defer util.SetCrawlWorkers(1)
+
+	hashes := util.GetAppPackageHashes(&ccApi, orgs)
+
+	if len(hashes) != 1 || hashes["a1"] != "abc123" {
+		t.Fatalf("unexpected package hashes %v", hashes)
+	}
+}
+
+func TestGetAppDroplets(t *testing.T) {
+	fakeResponses := map[string]string{
+		"/v3/apps/a1/droplets/current": `
+{
+   "guid": "d1",
+   "state": "STAGED",
+   "checksum": {"type": "sha256", "value": "def456"},
+   "process_types": {"web": "bundle exec rackup"}
+}
+`,
+		"/v3/apps/a2/droplets/current": `{"guid": "d2", "state": "STAGING"}`,
+	}
+	ccApi := CCApiMock{Responses: fakeResponses}
+
+	app := func(guid string, dockerImage interface{}) *models.ResourceModel {
+		return &models.ResourceModel{
+			Metadata: map[string]interface{}{"guid": guid},
+			Entity:   map[string]interface{}{"docker_image": dockerImage},
+		}
+	}
+	apps := []*models.ResourceModel{app("a1", nil), app("a2", nil), app("a3", "busybox")}
+	spaces := []*models.ResourceModel{{Entity: map[string]interface{}{"apps": &apps}}}
+	orgs := []*models.ResourceModel{{Entity: map[string]interface{}{"spaces": &spaces}}}
+	util.SetCrawlWorkers(4)
+	defer util.SetCrawlWorkers(1)
+
+	droplets := util.GetAppDroplets(&ccApi, orgs)
+
+	droplet, found := droplets["a1"]
+	if len(droplets) != 1 || !found || droplet.GUID != "d1" || droplet.Checksum != "def456" ||
+		droplet.ProcessTypes["web"] != "bundle exec rackup" {
+		t.Fatalf("unexpected droplets %+v", droplets)
+	}
+}
+
+func TestRestoreOrganizations_RecordedBackup(t *testing.T) {
+	fakeResponses := map[string]string{}
+	if err := json.Unmarshal([]byte(ccRecording1), &fakeResponses); err != nil {
+		t.Fatal("json.Unmarshal failed", err)
+	}
+	ccApi := CCApiMock{Responses: fakeResponses}
+
+	orgResources, err := util.GetOrgsResourcesRecurively(&ccApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupJSON, err := util.CreateBackupJSON(models.BackupModel{Organizations: orgResources})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := util.ReadBackupJSON([]byte(backupJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orgs, err := util.RestoreOrganizations(backup.Organizations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orgs) != 2 || len(orgs[0].Spaces) != 1 || len(orgs[0].Spaces[0].Apps) != 4 {
+		t.Fatalf("unexpected orgs %+v", orgs)
+	}
+	for _, app := range orgs[0].Spaces[0].Apps {
+		if !app.Docker() && app.Stack == "" {
+			t.Fatalf("stack is missing for %s", app.Name)
+		}
+	}
+}
+
+func TestRestoreOrganizations_FailedStackFetch(t *testing.T) {
+	fakeResponses := map[string]string{}
+	if err := json.Unmarshal([]byte(ccRecording1), &fakeResponses); err != nil {
+		t.Fatal("json.Unmarshal failed", err)
+	}
+	delete(fakeResponses, "/v2/stacks/61f8a8d0-4f59-4977-a65f-c4ec72849cb5")
+	ccApi := CCApiMock{Responses: fakeResponses}
+
+	orgResources, err := util.GetOrgsResourcesRecurively(&ccApi)
+	if _, partial := err.(*util.CrawlError); !partial {
+		t.Fatalf("expected a crawl error, got %v", err)
+	}
+	backupJSON, err := util.CreateBackupJSON(models.BackupModel{Organizations: orgResources})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, err := util.ReadBackupJSON([]byte(backupJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orgs, err := util.RestoreOrganizations(backup.Organizations)
+	skippedErr, partial := err.(*models.SkippedError)
+	if !partial {
+		t.Fatalf("expected the apps without a stack to be left out, got %v", err)
+	}
+	if len(skippedErr.Skipped) != 1 || skippedErr.Skipped[0].Kind != "app" || skippedErr.Skipped[0].Key() != "o/s/lt1" ||
+		skippedErr.Skipped[0].Error() != "organizations[0].spaces[0].apps[0].stack was not captured" {
+		t.Fatalf("unexpected skipped resources %v", skippedErr)
+	}
+	if len(orgs) != 2 || len(orgs[0].Spaces[0].Apps) != 3 {
+		t.Fatalf("the other apps should still be read, got %+v", orgs)
+	}
+	for _, app := range orgs[0].Spaces[0].Apps {
+		if app.Name == "lt1" {
+			t.Fatal("the app without a stack should be left out")
+		}
+	}
+}
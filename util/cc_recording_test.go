@@ -2,6 +2,10 @@ package util_test
 
 const ccRecording1 string = `
 {
+"/v2/apps/7bb0ec77-122b-4a74-9235-d772cee0d6a3/service_bindings": "{\n   \"total_results\": 0,\n   \"total_pages\": 1,\n   \"prev_url\": null,\n   \"next_url\": null,\n   \"resources\": []\n}",
+"/v2/apps/50f726be-ae64-48ff-bbd8-a86f252220e4/service_bindings": "{\n   \"total_results\": 0,\n   \"total_pages\": 1,\n   \"prev_url\": null,\n   \"next_url\": null,\n   \"resources\": []\n}",
+"/v2/apps/c5de6575-8424-4001-8a83-b1840bd5424b/service_bindings": "{\n   \"total_results\": 0,\n   \"total_pages\": 1,\n   \"prev_url\": null,\n   \"next_url\": null,\n   \"resources\": []\n}",
+"/v2/apps/72a72fa8-4f26-43e0-9209-53f64c237a1e/service_bindings": "{\n   \"total_results\": 0,\n   \"total_pages\": 1,\n   \"prev_url\": null,\n   \"next_url\": null,\n   \"resources\": []\n}",
 "/v2/apps/7bb0ec77-122b-4a74-9235-d772cee0d6a3/route_mappings": "{\n   \"total_results\": 1,\n   \"total_pages\": 1,\n   \"prev_url\": null,\n   \"next_url\": null,\n   \"resources\": [\n      {\n         \"metadata\": {\n            \"guid\": \"7d238fb4-e3b8-42d0-bda1-88189e642e98\",\n            \"url\": \"/v2/route_mappings/7d238fb4-e3b8-42d0-bda1-88189e642e98\",\n            \"created_at\": \"2016-06-21T09:56:39Z\",\n            \"updated_at\": null\n         },\n         \"entity\": {\n            \"app_port\": null,\n            \"app_guid\": \"7bb0ec77-122b-4a74-9235-d772cee0d6a3\",\n            \"route_guid\": \"8b249abd-df6f-4576-b8c8-b989e14a10b5\",\n            \"app_url\": \"/v2/apps/7bb0ec77-122b-4a74-9235-d772cee0d6a3\",\n            \"route_url\": \"/v2/routes/8b249abd-df6f-4576-b8c8-b989e14a10b5\"\n         }\n      }\n   ]\n}", 
 "/v2/users/0da05a75-985f-446e-841b-33651ba1934d/organizations": "{\n   \"total_results\": 1,\n   \"total_pages\": 1,\n   \"prev_url\": null,\n   \"next_url\": null,\n   \"resources\": [\n      {\n         \"metadata\": {\n            \"guid\": \"91656f3b-0e8d-4cea-9555-4460d309937a\",\n            \"url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a\",\n            \"created_at\": \"2016-06-17T09:09:44Z\",\n            \"updated_at\": null\n         },\n         \"entity\": {\n            \"name\": \"o\",\n            \"billing_enabled\": false,\n            \"quota_definition_guid\": \"8d331df5-4bea-4116-b64b-f9c90c3c14bb\",\n            \"status\": \"active\",\n            \"quota_definition_url\": \"/v2/quota_definitions/8d331df5-4bea-4116-b64b-f9c90c3c14bb\",\n            \"spaces_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/spaces\",\n            \"domains_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/domains\",\n            \"private_domains_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/private_domains\",\n            \"users_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/users\",\n            \"managers_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/managers\",\n            \"billing_managers_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/billing_managers\",\n            \"auditors_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/auditors\",\n            \"app_events_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/app_events\",\n            \"space_quota_definitions_url\": \"/v2/organizations/91656f3b-0e8d-4cea-9555-4460d309937a/space_quota_definitions\"\n         }\n      }\n   ]\n}", 
 "/v2/routes/8f62f651-9978-44ca-b4b6-ca12e4cf4cd2": "{\n   \"metadata\": {\n      \"guid\": \"8f62f651-9978-44ca-b4b6-ca12e4cf4cd2\",\n      \"url\": \"/v2/routes/8f62f651-9978-44ca-b4b6-ca12e4cf4cd2\",\n      \"created_at\": \"2016-06-17T09:10:06Z\",\n      \"updated_at\": null\n   },\n   \"entity\": {\n      \"host\": \"lt1\",\n      \"path\": \"\",\n      \"domain_guid\": \"cdf387b7-117c-45d4-af71-618e11fecdfe\",\n      \"space_guid\": \"fac8c0f5-0e48-4a1c-a8ef-13aae586a650\",\n      \"service_instance_guid\": null,\n      \"port\": null,\n      \"domain_url\": \"/v2/domains/cdf387b7-117c-45d4-af71-618e11fecdfe\",\n      \"space_url\": \"/v2/spaces/fac8c0f5-0e48-4a1c-a8ef-13aae586a650\",\n      \"apps_url\": \"/v2/routes/8f62f651-9978-44ca-b4b6-ca12e4cf4cd2/apps\",\n      \"route_mappings_url\": \"/v2/routes/8f62f651-9978-44ca-b4b6-ca12e4cf4cd2/route_mappings\"\n   }\n}", 
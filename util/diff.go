@@ -0,0 +1,307 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+)
+
+// Change kinds reported by DiffBackups
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// diffResourceTypes lists the compared resource types in report order
+var diffResourceTypes = []string{
+	"feature_flag",
+	"shared_domain",
+	"buildpack",
+	"org_quota",
+	"space_quota",
+	"security_group",
+	"organization",
+	"private_domain",
+	"space",
+	"service_instance",
+	"user_provided_service_instance",
+	"app",
+	"route",
+}
+
+// diffIgnoredFields are entity fields that change without user action
+var diffIgnoredFields = map[string]bool{
+	"version":         true,
+	"staging_task_id": true,
+}
+
+// FieldChange is the old and new value of a single field
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// ResourceChange describes how one resource differs between two backups
+type ResourceChange struct {
+	Type   string        `json:"type"`
+	Key    string        `json:"key"`
+	Change string        `json:"change"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// backupIndex holds the comparable fields of every resource of a backup,
+// by resource type and natural key
+type backupIndex map[string]map[string]map[string]interface{}
+
+func (index backupIndex) add(resourceType, key string, fields map[string]interface{}) {
+	if index[resourceType] == nil {
+		index[resourceType] = make(map[string]map[string]interface{})
+	}
+	index[resourceType][key] = fields
+}
+
+// comparableFields returns the plain entity fields; links, urls and guids
+// are left out as they differ between foundations
+func comparableFields(resource *models.ResourceModel) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for k, v := range resource.Entity {
+		if diffIgnoredFields[k] || strings.HasSuffix(k, urlSuffix) || strings.HasSuffix(k, "_guid") {
+			continue
+		}
+		if _, isRelation := resource.Entity[k+urlSuffix]; isRelation {
+			continue
+		}
+		fields[k] = v
+	}
+	return fields
+}
+
+func resourceList(value interface{}) []*models.ResourceModel {
+	if list, ok := value.(*[]*models.ResourceModel); ok && list != nil {
+		return *list
+	}
+	return nil
+}
+
+func resourceModels(transform func(interface{}) *[]*models.ResourceModel, value interface{}) []*models.ResourceModel {
+	if value == nil {
+		return nil
+	}
+	return *transform(value)
+}
+
+func entityString(resource *models.ResourceModel, key string) string {
+	if resource == nil || resource.Entity == nil || resource.Entity[key] == nil {
+		return ""
+	}
+	return fmt.Sprint(resource.Entity[key])
+}
+
+// usernames returns the sorted user names of a role relation
+func usernames(value interface{}) []interface{} {
+	var names []string
+	for _, user := range resourceList(value) {
+		names = append(names, entityString(user, "username"))
+	}
+	sort.Strings(names)
+
+	result := []interface{}{}
+	for _, name := range names {
+		result = append(result, name)
+	}
+	return result
+}
+
+// RouteKey returns the natural key of a route: host.domain[:port]/path
+func RouteKey(route *models.ResourceModel) string {
+	domain, _ := route.Entity["domain"].(*models.ResourceModel)
+	key := entityString(route, "host")
+	if domainName := entityString(domain, "name"); domainName != "" {
+		if key != "" {
+			key += "."
+		}
+		key += domainName
+	}
+	if port := entityString(route, "port"); port != "" {
+		key += ":" + port
+	}
+	return key + entityString(route, "path")
+}
+
+// indexBackup works on a copy of the backup, since transforming the raw
+// JSON into resource models rewrites the entities in place
+func indexBackup(original *models.BackupModel) (backupIndex, error) {
+	backupJSON, err := CreateBackupJSON(*original)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := ReadBackupJSON([]byte(backupJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(backupIndex)
+
+	if flags, ok := backup.FeatureFlags.([]interface{}); ok {
+		for _, flag := range *RestoreFlagsResourceModels(flags) {
+			index.add("feature_flag", flag.Name, map[string]interface{}{"enabled": flag.Enabled})
+		}
+	}
+	for _, domain := range resourceModels(CreateSharedDomainsCCResources(nil).TransformToResourceModels, backup.SharedDomains) {
+		index.add("shared_domain", entityString(domain, "name"), comparableFields(domain))
+	}
+	for _, buildpack := range resourceModels(RestoreBuildpackResourceModels, backup.Buildpacks) {
+		key := entityString(buildpack, "name")
+		if stack := entityString(buildpack, "stack"); stack != "" {
+			key += "/" + stack
+		}
+		index.add("buildpack", key, comparableFields(buildpack))
+	}
+	for _, quota := range resourceModels(RestoreQuotaResourceModels, backup.OrgQuotas) {
+		index.add("org_quota", entityString(quota, "name"), comparableFields(quota))
+	}
+	for _, group := range resourceModels(CreateSecurityGroupsCCResources(nil).TransformToResourceModels, backup.SecurityGroups) {
+		index.add("security_group", entityString(group, "name"), comparableFields(group))
+	}
+
+	orgNames := make(map[string]string)
+	spaceKeys := make(map[string]string)
+
+	for _, org := range resourceModels(RestoreOrgResourceModels, backup.Organizations) {
+		orgName := entityString(org, "name")
+		orgNames[fmt.Sprint(org.Metadata["guid"])] = orgName
+
+		fields := comparableFields(org)
+		for _, role := range []string{"auditors", "managers", "billing_managers"} {
+			if org.Entity[role] != nil {
+				fields[role] = usernames(org.Entity[role])
+			}
+		}
+		index.add("organization", orgName, fields)
+
+		for _, domain := range resourceList(org.Entity["private_domains"]) {
+			index.add("private_domain", entityString(domain, "name"), comparableFields(domain))
+		}
+
+		for _, space := range resourceList(org.Entity["spaces"]) {
+			spaceKey := orgName + "/" + entityString(space, "name")
+			spaceKeys[fmt.Sprint(space.Metadata["guid"])] = spaceKey
+
+			fields := comparableFields(space)
+			for _, role := range []string{"developers", "managers", "auditors"} {
+				if space.Entity[role] != nil {
+					fields[role] = usernames(space.Entity[role])
+				}
+			}
+			index.add("space", spaceKey, fields)
+
+			for _, instance := range resourceList(space.Entity["service_instances"]) {
+				index.add("service_instance", spaceKey+"/"+entityString(instance, "name"), comparableFields(instance))
+			}
+			for _, route := range resourceList(space.Entity["routes"]) {
+				index.add("route", RouteKey(route), comparableFields(route))
+			}
+
+			for _, app := range resourceList(space.Entity["apps"]) {
+				fields := comparableFields(app)
+				if stack, ok := app.Entity["stack"].(*models.ResourceModel); ok {
+					fields["stack"] = entityString(stack, "name")
+				}
+				if hash, ok := backup.PackageHashes[fmt.Sprint(app.Metadata["guid"])]; ok {
+					fields["package_hash"] = hash
+				}
+				if app.Entity["routes"] != nil {
+					var routes []string
+					for _, route := range resourceList(app.Entity["routes"]) {
+						routes = append(routes, RouteKey(route))
+						index.add("route", RouteKey(route), comparableFields(route))
+					}
+					sort.Strings(routes)
+					fields["routes"] = strings.Join(routes, ", ")
+				}
+				index.add("app", spaceKey+"/"+entityString(app, "name"), fields)
+			}
+		}
+	}
+
+	for _, quota := range resourceModels(RestoreSpaceQuotaResourceModels, backup.SpaceQuotas) {
+		index.add("space_quota", orgNames[entityString(quota, "organization_guid")]+"/"+entityString(quota, "name"), comparableFields(quota))
+	}
+	for _, instance := range resourceModels(RestoreUserProvidedServiceInstanceResourceModels, backup.UserProvidedServiceInstances) {
+		spaceKey := spaceKeys[entityString(instance, "space_guid")]
+		index.add("user_provided_service_instance", spaceKey+"/"+entityString(instance, "name"), comparableFields(instance))
+	}
+
+	return index, nil
+}
+
+// diffFields compares the fields both sides know about; a field missing on
+// one side comes from a different CC version or plugin version, not from a
+// change of the resource
+func diffFields(oldFields, newFields map[string]interface{}) []FieldChange {
+	var names []string
+	for name := range oldFields {
+		if _, found := newFields[name]; found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []FieldChange
+	for _, name := range names {
+		if !reflect.DeepEqual(oldFields[name], newFields[name]) {
+			changes = append(changes, FieldChange{Field: name, Old: oldFields[name], New: newFields[name]})
+		}
+	}
+	return changes
+}
+
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DiffBackups compares two backups resource by resource, matching resources
+// by their natural key (names rather than guids)
+func DiffBackups(oldBackup, newBackup *models.BackupModel) ([]ResourceChange, error) {
+	oldIndex, err := indexBackup(oldBackup)
+	if err != nil {
+		return nil, err
+	}
+	newIndex, err := indexBackup(newBackup)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ResourceChange{}
+	for _, resourceType := range diffResourceTypes {
+		oldResources, newResources := oldIndex[resourceType], newIndex[resourceType]
+
+		for _, key := range sortedKeys(oldResources) {
+			newFields, found := newResources[key]
+			if !found {
+				changes = append(changes, ResourceChange{Type: resourceType, Key: key, Change: ChangeRemoved})
+				continue
+			}
+			if fields := diffFields(oldResources[key], newFields); len(fields) > 0 {
+				changes = append(changes, ResourceChange{Type: resourceType, Key: key, Change: ChangeChanged, Fields: fields})
+			}
+		}
+		for _, key := range sortedKeys(newResources) {
+			if _, found := oldResources[key]; !found {
+				changes = append(changes, ResourceChange{Type: resourceType, Key: key, Change: ChangeAdded})
+			}
+		}
+	}
+
+	return changes, nil
+}
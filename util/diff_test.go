@@ -0,0 +1,160 @@
+package util_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func diffTestBackup(t *testing.T, memory int, appName string, developer string) *models.BackupModel {
+	backup := `{
+ "org_quota_definitions": [
+  {"metadata": {"guid": "q1", "url": "/v2/quota_definitions/q1"}, "entity": {"name": "default", "memory_limit": 10240}}
+ ],
+ "space_quota_definitions": null,
+ "shared_domains": [
+  {"metadata": {"guid": "d1", "url": "/v2/shared_domains/d1"}, "entity": {"name": "example.com"}}
+ ],
+ "security_groups": null,
+ "feature_flags": [
+  {"name": "user_org_creation", "enabled": false, "url": "/v2/config/feature_flags/user_org_creation"}
+ ],
+ "organizations": [
+  {
+   "metadata": {"guid": "o1", "url": "/v2/organizations/o1"},
+   "entity": {
+    "name": "org",
+    "quota_definition_guid": "q1",
+    "spaces_url": "/v2/organizations/o1/spaces",
+    "spaces": [
+     {
+      "metadata": {"guid": "s1", "url": "/v2/spaces/s1"},
+      "entity": {
+       "name": "space",
+       "organization_guid": "o1",
+       "developers_url": "/v2/spaces/s1/developers",
+       "apps_url": "/v2/spaces/s1/apps",
+       "developers": [
+        {"metadata": {"guid": "u1", "url": "/v2/users/u1"}, "entity": {"username": "` + developer + `"}}
+       ],
+       "apps": [
+        {
+         "metadata": {"guid": "a1", "url": "/v2/apps/a1"},
+         "entity": {
+          "name": "` + appName + `",
+          "memory": ` + strconv.Itoa(memory) + `,
+          "space_guid": "s1",
+          "space_url": "/v2/spaces/s1",
+          "routes_url": "/v2/apps/a1/routes",
+          "routes": [
+           {
+            "metadata": {"guid": "r1", "url": "/v2/routes/r1"},
+            "entity": {
+             "host": "` + appName + `",
+             "path": "",
+             "domain_url": "/v2/shared_domains/d1",
+             "domain": {"metadata": {"guid": "d1", "url": "/v2/shared_domains/d1"}, "entity": {"name": "example.com"}}
+            }
+           }
+          ]
+         }
+        }
+       ]
+      }
+     }
+    ]
+   }
+  }
+ ]
+}`
+	model, err := util.ReadBackupJSON([]byte(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func TestDiffBackups_SameBackupHasNoChanges(t *testing.T) {
+	backup := diffTestBackup(t, 1, "web", "alice")
+	same := diffTestBackup(t, 1, "web", "alice")
+
+	changes, err := util.DiffBackups(backup, same)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestDiffBackups_ReportsAddedRemovedAndChanged(t *testing.T) {
+	oldBackup := diffTestBackup(t, 1, "web", "alice")
+	newBackup := diffTestBackup(t, 2, "web", "bob")
+
+	changes, err := util.DiffBackups(oldBackup, newBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []util.ResourceChange{
+		{Type: "space", Key: "org/space", Change: util.ChangeChanged, Fields: []util.FieldChange{
+			{Field: "developers", Old: []interface{}{"alice"}, New: []interface{}{"bob"}},
+		}},
+		{Type: "app", Key: "org/space/web", Change: util.ChangeChanged, Fields: []util.FieldChange{
+			{Field: "memory", Old: float64(1), New: float64(2)},
+		}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, changes)
+	}
+
+	renamed := diffTestBackup(t, 1, "api", "alice")
+
+	changes, err = util.DiffBackups(oldBackup, renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary [][3]string
+	for _, change := range changes {
+		summary = append(summary, [3]string{change.Type, change.Key, change.Change})
+	}
+	expectedSummary := [][3]string{
+		{"app", "org/space/web", util.ChangeRemoved},
+		{"app", "org/space/api", util.ChangeAdded},
+		{"route", "web.example.com", util.ChangeRemoved},
+		{"route", "api.example.com", util.ChangeAdded},
+	}
+	if !reflect.DeepEqual(summary, expectedSummary) {
+		t.Fatalf("expected %v, got %v", expectedSummary, summary)
+	}
+}
+
+func TestDiffBackups_PackageHashes(t *testing.T) {
+	oldBackup := diffTestBackup(t, 1, "web", "alice")
+	newBackup := diffTestBackup(t, 1, "web", "alice")
+
+	newBackup.PackageHashes = map[string]string{"a1": "new"}
+	changes, err := util.DiffBackups(oldBackup, newBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("a hash missing from the old backup must not count as a change, got %+v", changes)
+	}
+
+	oldBackup.PackageHashes = map[string]string{"a1": "old"}
+	changes, err = util.DiffBackups(oldBackup, newBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []util.ResourceChange{
+		{Type: "app", Key: "org/space/web", Change: util.ChangeChanged, Fields: []util.FieldChange{
+			{Field: "package_hash", Old: "old", New: "new"},
+		}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, changes)
+	}
+}
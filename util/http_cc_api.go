@@ -0,0 +1,128 @@
+package util
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// CCApi sends requests of any method to the CC
+type CCApi interface {
+	cCApi
+	// Invoke sends a request with a JSON body, nil for none, and returns
+	// the response body
+	Invoke(method, path string, body []byte) ([]byte, error)
+}
+
+// HTTPError is a CC response with an error status code
+type HTTPError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Body       []byte
+}
+
+func (err *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", err.Method, err.Path, err.StatusCode, http.StatusText(err.StatusCode), string(err.Body))
+}
+
+// HTTPCCApi talks to the CC directly over HTTP, using the endpoint, the
+// token and the SSL settings of the cf cli. Connections are kept alive
+// between requests, and it is safe for concurrent use.
+type HTTPCCApi struct {
+	cli      plugin.CliConnection
+	endpoint string
+	token    accessToken
+	client   *http.Client
+	retry    RetryPolicy
+}
+
+// NewHTTPCCApi creates an HTTPCCApi for the CC the cf cli targets
+func NewHTTPCCApi(cli plugin.CliConnection) (*HTTPCCApi, error) {
+	endpoint, err := cli.ApiEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newCCHTTPClient(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	ccAPI := &HTTPCCApi{cli: cli, endpoint: endpoint, client: client, retry: retryPolicy}
+	if _, err := ccAPI.token.get(cli); err != nil {
+		return nil, err
+	}
+	return ccAPI, nil
+}
+
+// newCCHTTPClient creates an http client honouring the SSL settings of the
+// cf cli
+func newCCHTTPClient(cli plugin.CliConnection) (*http.Client, error) {
+	sslDisabled, err := cli.IsSSLDisabled()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: sslDisabled},
+			MaxIdleConnsPerHost: 16,
+		},
+	}, nil
+}
+
+// AuthError returns the error of a failed access token refresh. Requests
+// are not sent anymore after it, they all fail with this error.
+func (ccAPI *HTTPCCApi) AuthError() error {
+	return ccAPI.token.failure()
+}
+
+// InvokeGet invokes GET on a given path
+func (ccAPI *HTTPCCApi) InvokeGet(path string) (string, error) {
+	response, err := ccAPI.Invoke("GET", path, nil)
+	return string(response), err
+}
+
+// Invoke sends a request to the CC, retrying it according to the retry
+// policy, and refreshing the access token once it expired. Responses with
+// an error status code are returned together with an HTTPError.
+func (ccAPI *HTTPCCApi) Invoke(method, path string, body []byte) ([]byte, error) {
+	resp, err := ccAPI.token.send(ccAPI.cli, ccAPI.client, ccAPI.retry, func(token string) (*http.Request, error) {
+		var requestBody io.Reader
+		if body != nil {
+			requestBody = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequest(method, ccAPI.endpoint+path, requestBody)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The body is read to the end so that the connection can be reused
+	response, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return response, &HTTPError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: response}
+	}
+	return response, nil
+}
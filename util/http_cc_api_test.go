@@ -0,0 +1,230 @@
+package util_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin/pluginfakes"
+
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func newTestHTTPCCApi(t *testing.T, handler http.HandlerFunc) (*util.HTTPCCApi, func()) {
+	server := httptest.NewServer(handler)
+
+	cli := &pluginfakes.FakeCliConnection{}
+	cli.ApiEndpointReturns(server.URL, nil)
+	cli.AccessTokenReturns("bearer token", nil)
+
+	ccAPI, err := util.NewHTTPCCApi(cli)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return ccAPI, server.Close
+}
+
+func TestHTTPCCApi_SendsJSONBodies(t *testing.T) {
+	ccAPI, done := newTestHTTPCCApi(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "PUT" || r.URL.Path != "/v2/organizations/o1" ||
+			r.Header.Get("Authorization") != "bearer token" ||
+			r.Header.Get("Content-Type") != "application/json" ||
+			string(body) != `{"name":"o1"}` {
+			t.Errorf("unexpected request %s %s %v %s", r.Method, r.URL, r.Header, body)
+		}
+		w.Write([]byte("{\n  \"metadata\": {\"guid\": \"o1\"}\n}\n"))
+	})
+	defer done()
+
+	response, err := ccAPI.Invoke("PUT", "/v2/organizations/o1", []byte(`{"name":"o1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "{\n  \"metadata\": {\"guid\": \"o1\"}\n}\n" {
+		t.Fatalf("response was altered: %q", response)
+	}
+}
+
+func TestHTTPCCApi_ClientErrorsAreNotRetried(t *testing.T) {
+	requests := 0
+	ccAPI, done := newTestHTTPCCApi(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error_code":"CF-OrganizationNameTaken"}`))
+	})
+	defer done()
+
+	response, err := ccAPI.Invoke("POST", "/v2/organizations", []byte(`{"name":"o1"}`))
+
+	httpErr, ok := err.(*util.HTTPError)
+	if !ok {
+		t.Fatalf("expected an HTTPError, got %v", err)
+	}
+	if httpErr.StatusCode != http.StatusBadRequest || httpErr.Method != "POST" {
+		t.Fatalf("unexpected error %+v", httpErr)
+	}
+	if string(response) != `{"error_code":"CF-OrganizationNameTaken"}` {
+		t.Fatalf("the error body should be returned, got %q", response)
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single request, got %d", requests)
+	}
+}
+
+func TestHTTPCCApi_RetriesServerErrors(t *testing.T) {
+	util.SetRetryPolicy(util.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	defer util.SetRetryPolicy(util.DefaultRetryPolicy)
+
+	requests := 0
+	ccAPI, done := newTestHTTPCCApi(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"name":"o1"}` {
+			t.Errorf("attempt %d sent body %q", requests, body)
+		}
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"metadata":{"guid":"o1"}}`))
+		}
+	})
+	defer done()
+
+	if _, err := ccAPI.Invoke("PUT", "/v2/organizations/o1", []byte(`{"name":"o1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestHTTPCCApi_PostsAreOnlyRetriedWhenTurnedAway(t *testing.T) {
+	util.SetRetryPolicy(util.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	defer util.SetRetryPolicy(util.DefaultRetryPolicy)
+
+	requests := 0
+	ccAPI, done := newTestHTTPCCApi(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			// The app may have been created before the router gave up
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	defer done()
+
+	_, err := ccAPI.Invoke("POST", "/v2/apps", []byte(`{"name":"a1"}`))
+	if httpErr, ok := err.(*util.HTTPError); !ok || httpErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected a 502 HTTPError, got %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestHTTPCCApi_GivesUpAfterTheLastAttempt(t *testing.T) {
+	util.SetRetryPolicy(util.RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	defer util.SetRetryPolicy(util.DefaultRetryPolicy)
+
+	requests := 0
+	ccAPI, done := newTestHTTPCCApi(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer done()
+
+	_, err := ccAPI.Invoke("GET", "/v2/organizations", nil)
+	if httpErr, ok := err.(*util.HTTPError); !ok || httpErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 HTTPError, got %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestHTTPCCApi_RefreshesExpiredTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":1000,"error_code":"CF-InvalidAuthToken","description":"Invalid Auth Token"}`))
+			return
+		}
+		w.Write([]byte(`{"metadata":{"guid":"o1"}}`))
+	}))
+	defer server.Close()
+
+	tokens := []string{"bearer old", "bearer new"}
+	cli := &pluginfakes.FakeCliConnection{}
+	cli.ApiEndpointReturns(server.URL, nil)
+	cli.AccessTokenStub = func() (string, error) {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, nil
+	}
+
+	ccAPI, err := util.NewHTTPCCApi(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ccAPI.Invoke("GET", "/v2/organizations/o1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ccAPI.Invoke("GET", "/v2/organizations/o1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if cli.AccessTokenCallCount() != 2 {
+		t.Fatalf("expected the token to be refreshed once, got %d calls", cli.AccessTokenCallCount())
+	}
+}
+
+func TestHTTPCCApi_FailedRefreshIsAnError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"code":1000,"error_code":"CF-InvalidAuthToken","description":"Invalid Auth Token"}`))
+	}))
+	defer server.Close()
+
+	cli := &pluginfakes.FakeCliConnection{}
+	cli.ApiEndpointReturns(server.URL, nil)
+	cli.AccessTokenStub = func() (string, error) {
+		if cli.AccessTokenCallCount() > 1 {
+			return "", errors.New("refresh token expired")
+		}
+		return "bearer old", nil
+	}
+
+	ccAPI, err := util.NewHTTPCCApi(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ccAPI.Invoke("GET", "/v2/organizations", nil)
+	if err == nil || !strings.Contains(err.Error(), "refresh token expired") {
+		t.Fatalf("expected the refresh error, got %v", err)
+	}
+	if ccAPI.AuthError() != err {
+		t.Fatalf("expected the refresh error to be kept, got %v", ccAPI.AuthError())
+	}
+
+	requests := 0
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+	if _, err = ccAPI.Invoke("GET", "/v2/organizations", nil); err != ccAPI.AuthError() || requests != 0 {
+		t.Fatalf("no request should be sent after a failed refresh, got %v and %d requests", err, requests)
+	}
+}
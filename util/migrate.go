@@ -0,0 +1,88 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BackupFormatVersion is the format version of the backup json written by
+// this plugin version. Bump it together with a new entry in
+// backupMigrations whenever the captured data changes shape.
+const BackupFormatVersion = 3
+
+const formatVersionKey = "format_version"
+
+// backupMigration upgrades a decoded backup document by one format version
+type backupMigration func(document map[string]interface{}) error
+
+// backupMigrations holds the migration from each format version to the next
+var backupMigrations = map[int]backupMigration{
+	1: migrateBackupV1,
+	2: migrateBackupV2,
+}
+
+// migrateBackupV1 upgrades backups taken before format versions existed.
+// Version 2 holds the optional sections added until then: metadata, service
+// plans, user provided service instances, buildpacks and the package hashes
+// recorded for backup-drift (reused later to skip unchanged bits). A
+// restore skips them when they are missing, so nothing needs to be
+// converted.
+func migrateBackupV1(document map[string]interface{}) error {
+	return nil
+}
+
+// migrateBackupV2 upgrades backups without staged droplets. Version 3 added
+// the droplets section; apps without a droplet are restored from their
+// bits, so nothing needs to be converted.
+func migrateBackupV2(document map[string]interface{}) error {
+	return nil
+}
+
+// backupFormatVersion returns the format version of a decoded backup
+// document; documents without one are version 1
+func backupFormatVersion(document map[string]interface{}) (int, error) {
+	value, found := document[formatVersionKey]
+	if !found || value == nil {
+		return 1, nil
+	}
+
+	number, ok := value.(float64)
+	if !ok || number != float64(int(number)) || number < 1 {
+		return 0, fmt.Errorf("Invalid backup %s %v", formatVersionKey, value)
+	}
+
+	return int(number), nil
+}
+
+// migrateBackupJSON upgrades a backup json document step by step to
+// BackupFormatVersion. Documents from a newer plugin are rejected.
+func migrateBackupJSON(jsonBytes []byte) ([]byte, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &document); err != nil {
+		return nil, err
+	}
+
+	version, err := backupFormatVersion(document)
+	if err != nil {
+		return nil, err
+	}
+	if version > BackupFormatVersion {
+		return nil, fmt.Errorf("Backup format version %d is newer than version %d supported by this plugin, please upgrade the plugin", version, BackupFormatVersion)
+	}
+	if version == BackupFormatVersion {
+		return jsonBytes, nil
+	}
+
+	for ; version < BackupFormatVersion; version++ {
+		migrate, found := backupMigrations[version]
+		if !found {
+			return nil, fmt.Errorf("No migration from backup format version %d", version)
+		}
+		if err := migrate(document); err != nil {
+			return nil, fmt.Errorf("Failed to migrate backup from format version %d: %s", version, err)
+		}
+		document[formatVersionKey] = version + 1
+	}
+
+	return json.Marshal(document)
+}
@@ -0,0 +1,64 @@
+package util_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SUSE/cf-plugin-backup/models"
+	"github.com/SUSE/cf-plugin-backup/util"
+)
+
+func TestReadBackupJSON_MigratesUnversionedBackups(t *testing.T) {
+	backup, err := util.ReadBackupJSON([]byte(`{"organizations": [], "feature_flags": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if backup.FormatVersion != util.BackupFormatVersion {
+		t.Fatalf("expected format version %d, got %d", util.BackupFormatVersion, backup.FormatVersion)
+	}
+	if backup.Organizations == nil {
+		t.Fatal("organizations lost during migration")
+	}
+}
+
+func TestReadBackupJSON_RoundTrip(t *testing.T) {
+	backupJSON, err := util.CreateBackupJSON(models.BackupModel{Organizations: []interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := util.ReadBackupJSON([]byte(backupJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.FormatVersion != util.BackupFormatVersion {
+		t.Fatalf("expected format version %d, got %d", util.BackupFormatVersion, backup.FormatVersion)
+	}
+}
+
+func TestReadBackupJSON_RejectsUnknownVersions(t *testing.T) {
+	cases := map[string]string{
+		`{"format_version": 999}`:   "newer than version",
+		`{"format_version": "two"}`: "Invalid backup format_version",
+		`{"format_version": 1.5}`:   "Invalid backup format_version",
+	}
+
+	for backupJSON, message := range cases {
+		_, err := util.ReadBackupJSON([]byte(backupJSON))
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected an error containing %q, got %v", backupJSON, message, err)
+		}
+	}
+}
+
+func TestReadBackupJSON_MigratesBackupsWithoutDroplets(t *testing.T) {
+	backup, err := util.ReadBackupJSON([]byte(`{"format_version": 2, "organizations": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if backup.FormatVersion != util.BackupFormatVersion || len(backup.Droplets) != 0 {
+		t.Fatalf("unexpected migrated backup %+v", backup)
+	}
+}
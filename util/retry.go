@@ -0,0 +1,129 @@
+package util
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy tells how requests failing with a 5xx or 429 status code, or
+// with a connection error, are sent again. POST and PATCH requests are only
+// sent again when the server did not get them or turned them away with a
+// 429, as the first attempt may have been acted on.
+type RetryPolicy struct {
+	// Attempts is how many times a request is sent at most
+	Attempts int
+	// BaseDelay is the wait before the first retry. It doubles with every
+	// retry up to MaxDelay, and a random part of it is taken off so that
+	// clients do not retry in lockstep. A Retry-After header takes
+	// precedence.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy retries a request 4 times within 15 seconds, enough
+// to ride out a router restart
+var DefaultRetryPolicy = RetryPolicy{Attempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+var retryPolicy = DefaultRetryPolicy
+
+// SetRetryPolicy sets the retry policy of the requests sent to the CC and
+// the blobstore
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicy = policy
+}
+
+func retryable(method string, resp *http.Response, err error) bool {
+	if method == http.MethodPost || method == http.MethodPatch {
+		// Sending again a request that created an app, for instance,
+		// would fail with a name taken
+		if err != nil {
+			return notSent(err)
+		}
+		return resp.StatusCode == http.StatusTooManyRequests
+	}
+
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// notSent tells whether the request failed before it was sent, as it could
+// not connect
+func notSent(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// delay returns the wait before the given retry, counted from 1
+func (policy RetryPolicy) delay(retry int, resp *http.Response) time.Duration {
+	if wait, given := retryAfter(resp); given {
+		return wait
+	}
+
+	wait := policy.BaseDelay << uint(retry-1)
+	if wait > policy.MaxDelay || wait < 0 {
+		wait = policy.MaxDelay
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// send sends the request built by newRequest, retrying it as long as the
+// policy allows. The request is built anew for every attempt, as sending
+// it consumes its body. The last response or error is returned.
+func (policy RetryPolicy) send(client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	attempts := policy.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if attempt >= attempts || !retryable(req.Method, resp, err) {
+			return resp, err
+		}
+
+		wait := policy.delay(attempt, resp)
+		if err != nil {
+			log.Printf("%s %s failed: %v, retrying in %v (attempt %d of %d)", req.Method, req.URL.Path, err, wait, attempt+1, attempts)
+		} else {
+			log.Printf("%s %s failed: %s, retrying in %v (attempt %d of %d)", req.Method, req.URL.Path, resp.Status, wait, attempt+1, attempts)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		time.Sleep(wait)
+	}
+}